@@ -1,9 +1,20 @@
 package cmd
 
 import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/NBISweden/submitter/internal/config"
 	"github.com/spf13/cobra"
 )
 
+var profile string
+var allowProduction bool
+var fileSource string
+var version string
+
 var rootCmd = &cobra.Command{
 	Use:          "submitter",
 	Short:        "Runs dataset submissions",
@@ -11,6 +22,18 @@ var rootCmd = &cobra.Command{
 	SilenceUsage: true,
 }
 
+func init() {
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Name of the configuration profile (under PROFILES in the config file) to use")
+	rootCmd.PersistentFlags().StringVar(&fileSource, "source", "", "Where to read the user's files from, api or db. Defaults to db for job and lint and to api for the other commands")
+	rootCmd.PersistentFlags().BoolVar(&allowProduction, "i-know-this-is-prod", false, "Skip the confirmation prompt when the selected profile is marked as production")
+}
+
+// SetVersion sets the version reported by the commands, e.g. in the User-Agent of API requests
+func SetVersion(v string) {
+	version = v
+	rootCmd.Version = v
+}
+
 func Execute() error {
 	err := rootCmd.Execute()
 	if err != nil {
@@ -23,3 +46,54 @@ func Execute() error {
 func AddCommand(command *cobra.Command) {
 	rootCmd.AddCommand(command)
 }
+
+// LoadConfig reads the configuration for the profile selected with --profile and makes
+// sure that the operator has confirmed the use of a production profile
+func LoadConfig(configPath string) (*config.Config, error) {
+	cfg, err := config.NewConfig(configPath, profile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Version = version
+
+	if err := confirmProduction(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// SourceKind returns the file source selected with --source, or fallback if none was given
+func SourceKind(fallback string) string {
+	if fileSource == "" {
+		return fallback
+	}
+	return fileSource
+}
+
+func confirmProduction(cfg *config.Config) error {
+	if !cfg.Production || allowProduction {
+		return nil
+	}
+
+	stat, err := os.Stdin.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("profile %q is marked as production, rerun with --i-know-this-is-prod to continue", cfg.Profile)
+	}
+
+	name := cfg.Profile
+	if name == "" {
+		name = "production"
+	}
+	fmt.Fprintf(os.Stderr, "Profile %q is marked as production. Type the profile name to continue: ", name) //nolint:errcheck
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("could not read confirmation: %w", err)
+	}
+
+	if strings.TrimSpace(answer) != name {
+		return fmt.Errorf("confirmation did not match profile name, aborting")
+	}
+
+	return nil
+}
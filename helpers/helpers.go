@@ -2,6 +2,9 @@ package helpers
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 func GetFileIDsPath(dataDirectory string, datasetFolder string) string {
@@ -11,3 +14,76 @@ func GetFileIDsPath(dataDirectory string, datasetFolder string) string {
 func GetStableIDsPath(dataDirectory string, datasetFolder string) string {
 	return fmt.Sprintf("%s/%s-stableIDs.txt", dataDirectory, datasetFolder)
 }
+
+func GetReportPath(dataDirectory string, datasetFolder string) string {
+	return fmt.Sprintf("%s/%s-report.json", dataDirectory, datasetFolder)
+}
+
+func GetAlertStatePath(dataDirectory string, datasetFolder string) string {
+	return fmt.Sprintf("%s/%s-alert.json", dataDirectory, datasetFolder)
+}
+
+func GetChunkStatePath(dataDirectory string, datasetFolder string) string {
+	return fmt.Sprintf("%s/%s-chunks.json", dataDirectory, datasetFolder)
+}
+
+func GetHistoryPath(dataDirectory string) string {
+	return fmt.Sprintf("%s/history.db", dataDirectory)
+}
+
+// HumanSize formats a size in bytes with binary units, e.g. 1.5 GiB
+func HumanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// RelativeToFolder strips everything up to and including the dataset folder from an inbox
+// path, e.g. "user/DATASET_ABC/IMAGES/a.c4gh" becomes "IMAGES/a.c4gh"
+func RelativeToFolder(inboxPath string, datasetFolder string) string {
+	marker := datasetFolder + "/"
+	if i := strings.Index(inboxPath, marker); i >= 0 {
+		return inboxPath[i+len(marker):]
+	}
+	return strings.TrimPrefix(inboxPath, "/")
+}
+
+// WriteFileAtomic writes data to a temporary file next to path and renames it into place, so
+// that readers never see a partially written file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
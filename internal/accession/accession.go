@@ -2,21 +2,23 @@ package accession
 
 import (
 	"crypto/rand"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"log/slog"
 	"math/big"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/NBISweden/submitter/cmd"
 	"github.com/NBISweden/submitter/helpers"
 	"github.com/NBISweden/submitter/internal/client"
-	"github.com/NBISweden/submitter/internal/config"
-	"github.com/NBISweden/submitter/internal/database"
+	"github.com/NBISweden/submitter/internal/history"
 	"github.com/NBISweden/submitter/internal/models"
+	"github.com/NBISweden/submitter/internal/report"
+	"github.com/NBISweden/submitter/internal/source"
 	"github.com/spf13/cobra"
 )
 
@@ -32,8 +34,8 @@ var accessionCmd = &cobra.Command{
 	Args: func(cmd *cobra.Command, args []string) error {
 		return nil
 	},
-	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewConfig(configPath)
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := cmd.LoadConfig(configPath)
 		if err != nil {
 			return err
 		}
@@ -45,6 +47,7 @@ var accessionCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		defer api.Close() //nolint:errcheck
 
 		filePath := helpers.GetFileIDsPath(dataDirectory, datasetFolder)
 		file, err := createFileIDFile(filePath, dryRun)
@@ -54,29 +57,39 @@ var accessionCmd = &cobra.Command{
 		}
 		defer file.Close() //nolint:errcheck
 
-		files, err := api.GetUsersFiles()
+		src, closeSource, err := source.Open(cmd.SourceKind(source.KindAPI), cfg)
 		if err != nil {
 			return err
 		}
+		defer closeSource()
 
-		paths := getPathsForAccessionIDs(files)
+		paths, err := getPathsForAccessionIDs(src.Stream("verified"), nil)
+		if err != nil {
+			return err
+		}
 		if dryRun {
 			slog.Info("dry run enabled, no accession ids will be created")
 			return nil
 		}
-		accessionIDs, err := postAccessionIDs(api, paths, userID, datasetFolder)
 
-		for _, accessionID := range accessionIDs {
-			if _, err := file.WriteString(accessionID + "\n"); err != nil {
-				return err
+		return history.RecordCommand(dataDirectory, "accession", cfg, func(rep *report.Report) error {
+			rep.CountRetries(api.Retries)
+			step := rep.StartStep("accession")
+			accessioned, err := postAccessionIDs(api, paths, userID, datasetFolder, step)
+
+			for _, f := range accessioned {
+				if _, err := file.WriteString(f.AccessionID + "\n"); err != nil {
+					return err
+				}
 			}
-		}
 
-		if err != nil {
-			return err
-		}
+			if err != nil {
+				return err
+			}
+			step.Finish(len(accessioned), nil)
 
-		return nil
+			return nil
+		})
 	},
 }
 
@@ -84,69 +97,119 @@ func init() {
 	cmd.AddCommand(accessionCmd)
 	accessionCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Toggles dry-run mode. Dry run will not run any state changing API calls")
 	accessionCmd.Flags().StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
-	accessionCmd.Flags().StringVar(&dataDirectory, "data-directory", "data", "Path to directory to write / read intermediate files for stableIDs and fileIDs")
+	accessionCmd.Flags().StringVar(&dataDirectory, "data-directory", "data", "Path to directory to write / read intermediate files for stableIDs and fileIDs, and holding the history")
 }
 
-func Run(api client.APIClient, db database.PostgresDb, datasetFolder string, userID string) ([]string, error) {
-	slog.Info("starting accession")
-	files, err := db.GetUserFiles(userID, datasetFolder, true)
-	if err != nil {
-		return nil, err
+// Run assigns accession ids to the verified files in the dataset folder and returns the files
+// with their accession ids. On error the files that got an accession id before it are returned.
+func Run(api client.APIClient, src source.FileSource, datasetFolder string, userID string, step *report.Step) ([]models.FileInfo, error) {
+	return Resume(api, src, datasetFolder, userID, nil, step)
+}
+
+// Resume is Run for a dataset where saved already got their accession ids, e.g. by a job that
+// stopped partway through accession. Only the verified files that are not in saved get an
+// accession id, saved is returned followed by the newly accessioned files.
+func Resume(api client.APIClient, src source.FileSource, datasetFolder string, userID string, saved []models.FileInfo, step *report.Step) ([]models.FileInfo, error) {
+	slog.Info("starting accession", "already_accessioned", len(saved))
+	done := map[string]bool{}
+	for _, f := range saved {
+		done[f.InboxPath] = true
 	}
 
-	paths := getPathsForAccessionIDs(files)
-	accessionIDs, err := postAccessionIDs(api, paths, userID, datasetFolder)
+	paths, err := getPathsForAccessionIDs(src.Stream("verified"), done)
 	if err != nil {
-		return nil, err
+		return saved, err
+	}
+	accessioned, err := postAccessionIDs(api, paths, userID, datasetFolder, step)
+	accessioned = slices.Concat(saved, accessioned)
+	if err != nil {
+		return accessioned, err
 	}
 
 	slog.Info("accession complete")
-	return accessionIDs, nil
+	return accessioned, nil
 }
 
-func getPathsForAccessionIDs(files []models.FileInfo) []string {
+// getPathsForAccessionIDs reads files one at a time and keeps the paths of the verified files
+// outside the PRIVATE folder that are not done
+func getPathsForAccessionIDs(files iter.Seq2[models.FileInfo, error], done map[string]bool) ([]string, error) {
 	var paths []string
-	for _, f := range files {
+	for f, err := range files {
+		if err != nil {
+			return nil, err
+		}
 		if f.Status == "verified" &&
 			strings.Contains(f.InboxPath, datasetFolder) &&
-			!strings.Contains(f.InboxPath, "PRIVATE") {
+			!strings.Contains(f.InboxPath, "PRIVATE") &&
+			!done[f.InboxPath] {
 			paths = append(paths, f.InboxPath)
 		}
 	}
 	slog.Info("files found for accession id creation", "files_found", len(paths))
-	return paths
+	return paths, nil
 }
 
-func postAccessionIDs(api client.APIClient, paths []string, userID string, datasetFolder string) ([]string, error) {
-	var accessionIDs []string
+// duplicateRetries is how many new accession ids are tried when the api reports that the
+// generated one is already in use
+const duplicateRetries = 3
+
+// setAccession assigns accessionID to the file and returns the id that was assigned, which is
+// a newly generated one if accessionID was already in use
+func setAccession(api client.APIClient, accessionID string, filepath string, userID string) (string, error) {
+	for range duplicateRetries {
+		err := api.SetAccession(accessionID, filepath, userID)
+		if !errors.Is(err, client.ErrDuplicateAccession) {
+			return accessionID, err
+		}
+
+		slog.Warn("accession id already in use, generating a new one", "accession_id", accessionID, "filepath", filepath)
+		if accessionID, err = generateAccessionID(); err != nil {
+			return "", err
+		}
+	}
+	return accessionID, api.SetAccession(accessionID, filepath, userID)
+}
+
+func postAccessionIDs(api client.APIClient, paths []string, userID string, datasetFolder string, step *report.Step) ([]models.FileInfo, error) {
+	var accessioned []models.FileInfo
 	for _, filepath := range paths {
 		accessionID, err := generateAccessionID()
 		if err != nil {
-			return accessionIDs, err
+			return accessioned, err
 		}
 
-		payload, err := json.Marshal(map[string]string{
-			"accession_id": accessionID,
-			"filepath":     filepath,
-			"user":         userID,
-		})
-		if err != nil {
-			return accessionIDs, err
+		accessionID, err = setAccession(api, accessionID, filepath, userID)
+		switch {
+		case err == nil:
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			step.AddProblem("%s: accession request ended unexpectedly", filepath)
+			continue
+		case errors.Is(err, client.ErrFileNotFound):
+			step.AddProblem("%s: %v", filepath, err)
+			continue
+		default:
+			return accessioned, err
 		}
+		accessioned = append(accessioned, models.FileInfo{AccessionID: accessionID, InboxPath: filepath})
+	}
 
-		resp, err := api.PostFileAccession(payload)
-		if err != nil {
-			if errors.Is(err, io.ErrUnexpectedEOF) {
-				continue
-			}
-			return accessionIDs, err
-		}
-		defer resp.Body.Close() //nolint:errcheck
-		accessionIDs = append(accessionIDs, accessionID)
+	slog.Info("accession IDs assigned", "nr_files", len(accessioned), "requested", len(paths))
+	return accessioned, nil
+}
+
+// WriteFileIDsFile writes one accession id per line to filePath, replacing the file if it exists
+func WriteFileIDsFile(filePath string, files []models.FileInfo) error {
+	var b strings.Builder
+	for _, f := range files {
+		b.WriteString(f.AccessionID + "\n")
+	}
+
+	if err := helpers.WriteFileAtomic(filePath, []byte(b.String()), 0640); err != nil {
+		return err
 	}
 
-	slog.Info("accession IDs assigned", "nr_files", len(paths))
-	return accessionIDs, nil
+	slog.Info("created file with accession ids", "filePath", filePath)
+	return nil
 }
 
 func createFileIDFile(fileIDPath string, dryrun bool) (*os.File, error) {
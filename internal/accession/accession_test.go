@@ -1,10 +1,8 @@
 package accession
 
 import (
-	"bytes"
 	"fmt"
-	"io"
-	"net/http"
+	"iter"
 	"os"
 	"path/filepath"
 	"testing"
@@ -14,19 +12,26 @@ import (
 
 type mockClient struct {
 	FilesToReturn []models.FileInfo
-	Response      *http.Response
+	Errors        map[string]error
 }
 
-func (m *mockClient) GetUsersFiles() ([]models.FileInfo, error) {
-	return m.FilesToReturn, nil
+func (m *mockClient) IngestFile(filepath string, user string) error {
+	return m.Errors[filepath]
 }
 
-func (m *mockClient) PostFileIngest(data []byte) (*http.Response, error) {
-	return m.Response, nil
+func (m *mockClient) SetAccession(accessionID string, filepath string, user string) error {
+	return m.Errors[filepath]
 }
 
-func (m *mockClient) PostFileAccession(payload []byte) (*http.Response, error) {
-	return m.Response, nil
+// stream lists files the way a file source does
+func stream(files []models.FileInfo) iter.Seq2[models.FileInfo, error] {
+	return func(yield func(models.FileInfo, error) bool) {
+		for _, f := range files {
+			if !yield(f, nil) {
+				return
+			}
+		}
+	}
 }
 
 func newMockClient(userID string, datasetFolder string) *mockClient {
@@ -36,7 +41,6 @@ func newMockClient(userID string, datasetFolder string) *mockClient {
 			{InboxPath: fmt.Sprintf("/%s/%s/file2.c4gh", userID, datasetFolder), Status: "verified"},
 			{InboxPath: fmt.Sprintf("/%s/%s/file3.c4gh", userID, datasetFolder), Status: "error"},
 		},
-		Response: &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString("ok"))},
 	}
 	return mock
 }
@@ -54,11 +58,10 @@ func TestAccession(t *testing.T) {
 
 	t.Run("Test Accession", func(t *testing.T) {
 		accessionCmd.Flag("data-directory").Value.Set(workingDirectory)
-		files, err := mock.GetUsersFiles()
+		paths, err := getPathsForAccessionIDs(stream(mock.FilesToReturn), nil)
 		if err != nil {
 			t.Error(err)
 		}
-		paths := getPathsForAccessionIDs(files)
 		recievedPaths := len(paths)
 		if recievedPaths != expectedPaths {
 			t.Logf("recieved %d/%d paths for accessionIDs", recievedPaths, expectedPaths)
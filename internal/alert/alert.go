@@ -0,0 +1,125 @@
+package alert
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/NBISweden/submitter/helpers"
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/mail"
+	"github.com/NBISweden/submitter/internal/notify"
+	"github.com/NBISweden/submitter/internal/report"
+)
+
+// state is persisted in the data directory to tell if the same failure was already alerted
+type state struct {
+	Fingerprint string    `json:"fingerprint"`
+	SentAt      time.Time `json:"sent_at"`
+}
+
+// Send notifies operators that a job failed, by mail to the recipients subscribed to the
+// job_failed event and through the notifiers subscribed to it, which includes
+// ALERT_WEBHOOK_URL. A failure identical to the last one alerted within ALERT_DEDUP_WINDOW is
+// not sent again. The failure counts as alerted as soon as one delivery succeeded, so a broken
+// channel does not make the others repeat the alert on every run.
+func Send(cfg *config.Config, dataDirectory string, notifier *notify.Dispatcher, rep *report.Report) error {
+	if rep.Failure == nil {
+		return nil
+	}
+
+	statePath := helpers.GetAlertStatePath(dataDirectory, cfg.DatasetFolder)
+	fingerprint := fingerprint(rep)
+	window := time.Minute * time.Duration(cfg.AlertDedupWindow)
+	if alreadySent(statePath, fingerprint, window) {
+		slog.Info("[alert] identical failure already alerted, skipping", "step", rep.Failure.Step, "window", window)
+		return nil
+	}
+
+	var errs []error
+	sent := 0
+	m, err := mail.New(cfg, dataDirectory)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if err == nil && m.HasRecipients(mail.EventJobFailed) {
+		data := m.Data()
+		data.JobStartedAt = rep.StartedAt
+		data.JobFinishedAt = rep.FinishedAt
+		data.Failure = &mail.Failure{
+			Step:     rep.Failure.Step,
+			Error:    rep.Failure.Error,
+			Problems: rep.Failure.Problems,
+			Progress: rep.Progress(),
+		}
+		m.SetData(data)
+		for _, delivery := range m.Deliver(mail.EventJobFailed, false) {
+			rep.AddNotification("mail", delivery.Name, mail.EventJobFailed, delivery.Err)
+			if delivery.Err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", delivery.Name, delivery.Err))
+			} else {
+				sent++
+			}
+		}
+	}
+
+	event := notify.NewEvent(notify.EventJobFailed, rep, fmt.Sprintf("job failed in step %s", rep.Failure.Step))
+	event.Step = rep.Failure.Step
+	event.Error = rep.Failure.Error
+	event.Problems = rep.Failure.Problems
+	for _, delivery := range notifier.Deliver(context.Background(), event) {
+		rep.AddNotification("notifier", delivery.Name, event.Type, delivery.Err)
+		if delivery.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", delivery.Name, delivery.Err))
+		} else {
+			sent++
+		}
+	}
+
+	if sent > 0 {
+		if err := saveState(statePath, fingerprint); err != nil {
+			slog.Warn("[alert] could not save alert state", "err", err)
+		}
+		slog.Info("[alert] failure alert sent", "step", rep.Failure.Step, "deliveries", sent)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to send failure alert: %w", err)
+	}
+
+	return nil
+}
+
+func fingerprint(rep *report.Report) string {
+	sum := sha256.Sum256([]byte(rep.DatasetID + "\n" + rep.Failure.Step + "\n" + rep.Failure.Error))
+	return hex.EncodeToString(sum[:])
+}
+
+func alreadySent(path string, fingerprint string, window time.Duration) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return false
+	}
+
+	return s.Fingerprint == fingerprint && time.Since(s.SentAt) < window
+}
+
+func saveState(path string, fingerprint string) error {
+	data, err := json.Marshal(state{Fingerprint: fingerprint, SentAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	return helpers.WriteFileAtomic(path, data, 0640)
+}
@@ -0,0 +1,104 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/notify"
+	"github.com/NBISweden/submitter/internal/report"
+)
+
+func TestSendDeduplicatesAlerts(t *testing.T) {
+	var received []notify.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p notify.Event
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		received = append(received, p)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		DatasetFolder:    "DATASET_ABC",
+		DatasetID:        "aa-Dataset-abc",
+		AlertWebhookURL:  server.URL,
+		AlertDedupWindow: 60,
+	}
+	dataDir := t.TempDir()
+	notifier, err := notify.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failedReport := func(message string) *report.Report {
+		rep := report.New(cfg.DatasetFolder, cfg.DatasetID, "user", 2)
+		step := rep.StartStep("ingest")
+		step.AddProblem("DATASET_ABC/file2.c4gh: ingest returned 400 Bad Request")
+		rep.Finish(errors.New(message))
+		return rep
+	}
+
+	for range 2 {
+		if err := Send(cfg, dataDir, notifier, failedReport("ingest did not return the expected number of files")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(received) != 1 {
+		t.Fatalf("expected identical failures to be alerted once, got %d alerts", len(received))
+	}
+	if received[0].Step != "ingest" || len(received[0].Problems) != 1 {
+		t.Errorf("unexpected alert payload: %+v", received[0])
+	}
+
+	if err := Send(cfg, dataDir, notifier, failedReport("another error")); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 {
+		t.Errorf("expected a different failure to be alerted, got %d alerts", len(received))
+	}
+}
+
+func TestSendSavesStateAfterPartialFailure(t *testing.T) {
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		received++
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		DatasetFolder:    "DATASET_ABC",
+		DatasetID:        "aa-Dataset-abc",
+		AlertWebhookURL:  server.URL,
+		AlertDedupWindow: 60,
+		Notifiers:        []config.Notifier{{Name: "broken", Type: notify.TypeWebhook, URL: server.URL + "/broken"}},
+	}
+	dataDir := filepath.Join(t.TempDir(), "missing")
+	notifier, err := notify.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep := report.New(cfg.DatasetFolder, cfg.DatasetID, "user", 2)
+	rep.StartStep("ingest")
+	rep.Finish(errors.New("ingest failed"))
+
+	if err := Send(cfg, dataDir, notifier, rep); err == nil {
+		t.Error("expected the failed delivery to be reported")
+	}
+	if err := Send(cfg, dataDir, notifier, rep); err != nil {
+		t.Errorf("expected the alert not to be sent again, got %v", err)
+	}
+	if received != 1 {
+		t.Errorf("expected the working channel to be alerted once, got %d alerts", received)
+	}
+}
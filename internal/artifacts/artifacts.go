@@ -0,0 +1,199 @@
+package artifacts
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/NBISweden/submitter/cmd"
+	"github.com/NBISweden/submitter/helpers"
+	"github.com/NBISweden/submitter/internal/attachments"
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/dataset"
+	"github.com/NBISweden/submitter/internal/history"
+	"github.com/NBISweden/submitter/internal/models"
+	"github.com/NBISweden/submitter/internal/report"
+	"github.com/spf13/cobra"
+)
+
+//go:embed templates/*.txt
+var templateFS embed.FS
+var dryRun bool
+var validateOnly bool
+var configPath string
+var dataDirectory string
+
+var artifactsCmd = &cobra.Command{
+	Use:   "artifacts [flags]",
+	Short: "Generate dataset.txt, policy.txt and rems.txt",
+	Long:  "Generate the dataset.txt, policy.txt and rems.txt mail attachments from templates and validate them",
+	Args: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := cmd.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+
+		if validateOnly {
+			return Validate(dataDirectory, cfg.DatasetID)
+		}
+
+		files, err := dataset.ReadStableIDsFile(helpers.GetStableIDsPath(dataDirectory, cfg.DatasetFolder))
+		if err != nil {
+			return fmt.Errorf("could not read accession ids: %w", err)
+		}
+
+		if dryRun {
+			rendered, err := Render(cfg, files)
+			if err != nil {
+				return err
+			}
+			for _, name := range attachments.Names {
+				fmt.Printf("==> %s <==\n%s\n", name, rendered[name])
+			}
+			return nil
+		}
+
+		return history.RecordCommand(dataDirectory, "artifacts", cfg, func(rep *report.Report) error {
+			step := rep.StartStep("artifacts")
+			if err := Write(cfg, dataDirectory, files); err != nil {
+				return err
+			}
+			step.Finish(len(attachments.Names), nil)
+
+			return nil
+		})
+	},
+}
+
+func init() {
+	cmd.AddCommand(artifactsCmd)
+	artifactsCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Toggles dry-run mode. Dry run prints the rendered files instead of writing them")
+	artifactsCmd.Flags().BoolVar(&validateOnly, "validate-only", false, "Only validate existing files in the data directory")
+	artifactsCmd.Flags().StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
+	artifactsCmd.Flags().StringVar(&dataDirectory, "data-directory", "data", "Path to directory to read the stable ids file from and write the generated files to, and holding the history")
+}
+
+type Data struct {
+	DatasetID     string
+	DatasetFolder string
+	UserID        string
+	Uploader      string
+	UploaderEmail string
+	AccessionIDs  []string
+	FileCount     int
+	TotalSize     int64
+	Date          string
+	Params        map[string]string
+}
+
+// Render renders every artifact for the dataset made up of files. Templates in
+// ARTIFACTS_TEMPLATE_DIR take precedence over the built-in ones.
+func Render(cfg *config.Config, files []models.FileInfo) (map[string]string, error) {
+	data := Data{
+		DatasetID:     cfg.DatasetID,
+		DatasetFolder: cfg.DatasetFolder,
+		UserID:        cfg.UserID,
+		Uploader:      cfg.MailUploaderName,
+		UploaderEmail: cfg.MailUploader,
+		FileCount:     len(files),
+		Date:          time.Now().UTC().Format(time.DateOnly),
+		Params:        params(cfg),
+	}
+	for _, f := range files {
+		data.AccessionIDs = append(data.AccessionIDs, f.AccessionID)
+		data.TotalSize += f.Size
+	}
+	slices.Sort(data.AccessionIDs)
+
+	rendered := map[string]string{}
+	for _, name := range attachments.Names {
+		content, err := readTemplate(cfg.ArtifactsTemplateDir, name)
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl, err := template.New(name).Funcs(map[string]any{"humanSize": helpers.HumanSize}).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse template %s: %w", name, err)
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("could not render %s: %w", name, err)
+		}
+		rendered[name] = buf.String()
+	}
+
+	return rendered, nil
+}
+
+// params returns ARTIFACTS_PARAMETERS on top of defaults for the parameters of the built-in
+// templates, so that they render without any parameters set: title defaults to REMS_TITLE or
+// DATASET_ID, organization to REMS_ORGANIZATION and policy_id to empty. Other parameters used
+// in custom templates still have to be set.
+func params(cfg *config.Config) map[string]string {
+	title := cfg.RemsTitle
+	if title == "" {
+		title = cfg.DatasetID
+	}
+	p := map[string]string{"title": title, "organization": cfg.RemsOrganization, "policy_id": ""}
+	for key, value := range cfg.ArtifactsParameters {
+		p[key] = value
+	}
+	return p
+}
+
+// Write renders every artifact to the data directory and validates the result
+func Write(cfg *config.Config, dataDirectory string, files []models.FileInfo) error {
+	rendered, err := Render(cfg, files)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range attachments.Names {
+		path := filepath.Join(dataDirectory, name)
+		if err := helpers.WriteFileAtomic(path, []byte(rendered[name]), 0640); err != nil {
+			return err
+		}
+		slog.Info("wrote artifact", "path", path)
+	}
+
+	return Validate(dataDirectory, cfg.DatasetID)
+}
+
+// Validate checks that every artifact in the data directory exists and is complete
+func Validate(dataDirectory string, datasetID string) error {
+	var errs []error
+	for _, name := range attachments.Names {
+		if err := attachments.ValidateFile(filepath.Join(dataDirectory, name), datasetID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func readTemplate(templateDir string, filename string) ([]byte, error) {
+	if templateDir != "" {
+		content, err := os.ReadFile(filepath.Join(templateDir, filename))
+		if err == nil {
+			return content, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return templateFS.ReadFile("templates/" + filename)
+}
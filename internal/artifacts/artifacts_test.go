@@ -0,0 +1,82 @@
+package artifacts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/attachments"
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+func TestArtifacts(t *testing.T) {
+	cfg := &config.Config{
+		DatasetID:        "aa-Dataset-abc",
+		DatasetFolder:    "DATASET_ABC",
+		MailUploaderName: "Jane Doe",
+		MailUploader:     "jane@example.com",
+		ArtifactsParameters: map[string]string{
+			"title":        "A test dataset",
+			"policy_id":    "aa-Policy-abc",
+			"organization": "NBIS",
+		},
+	}
+	files := []models.FileInfo{
+		{AccessionID: "aa-File-bbbbbb-bbbbbb", InboxPath: "DATASET_ABC/file2.c4gh", Size: 2048},
+		{AccessionID: "aa-File-aaaaaa-aaaaaa", InboxPath: "DATASET_ABC/file1.c4gh", Size: 1024},
+	}
+
+	t.Run("Write and validate", func(t *testing.T) {
+		dataDir := t.TempDir()
+		if err := Write(cfg, dataDir, files); err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := os.ReadFile(filepath.Join(dataDir, "dataset.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dataset := string(content)
+		if !strings.Contains(dataset, "Number of files: 2") || !strings.Contains(dataset, "Total size: 3.0 KiB") {
+			t.Errorf("unexpected dataset.txt:\n%s", dataset)
+		}
+		if strings.Index(dataset, "aa-File-aaaaaa") > strings.Index(dataset, "aa-File-bbbbbb") {
+			t.Error("expected accession ids to be sorted")
+		}
+	})
+
+	t.Run("Without parameters", func(t *testing.T) {
+		defaults := *cfg
+		defaults.ArtifactsParameters = nil
+		rendered, err := Render(&defaults, files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(rendered["dataset.txt"], "Title: aa-Dataset-abc\n") {
+			t.Errorf("expected the title to default to the dataset id:\n%s", rendered["dataset.txt"])
+		}
+	})
+
+	t.Run("Missing parameter", func(t *testing.T) {
+		custom := *cfg
+		custom.ArtifactsTemplateDir = t.TempDir()
+		if err := os.WriteFile(filepath.Join(custom.ArtifactsTemplateDir, "policy.txt"), []byte("License: {{.Params.license}}\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Render(&custom, files); err == nil {
+			t.Error("expected error when a parameter of a custom template is missing")
+		}
+	})
+
+	t.Run("Validate hand written file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "policy.txt")
+		if err := os.WriteFile(path, []byte("Policy for aa-Dataset-other\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := attachments.ValidateFile(path, cfg.DatasetID); err == nil {
+			t.Error("expected error for artifact about another dataset")
+		}
+	})
+}
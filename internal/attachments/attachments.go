@@ -0,0 +1,40 @@
+// Package attachments knows the files generated by the artifacts command, so that the mail
+// package can check them before attaching them without depending on the command
+package attachments
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Names are the attachment files rendered by the artifacts command
+var Names = []string{"dataset.txt", "policy.txt", "rems.txt"}
+
+// IsArtifact reports whether path is one of the generated artifacts
+func IsArtifact(path string) bool {
+	return slices.Contains(Names, filepath.Base(path))
+}
+
+// ValidateFile checks that an artifact is not empty, has no unrendered placeholders and is
+// about the dataset with datasetID
+func ValidateFile(path string, datasetID string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("artifact %s: %w", path, err)
+	}
+
+	text := string(content)
+	switch {
+	case strings.TrimSpace(text) == "":
+		return fmt.Errorf("artifact %s is empty", path)
+	case strings.Contains(text, "<no value>"), strings.Contains(text, "{{"):
+		return fmt.Errorf("artifact %s contains unrendered template values", path)
+	case datasetID != "" && !strings.Contains(text, datasetID):
+		return fmt.Errorf("artifact %s does not mention dataset %s", path, datasetID)
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cassette modes selected with CLIENT_CASSETTE_MODE
+const (
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+// redacted replaces credentials in recorded requests and responses
+const redacted = "REDACTED"
+
+// sensitiveHeaders are never written to a cassette
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Rems-Api-Key"}
+
+// Cassette holds the requests made to the SDA API and the responses to them, in order. It is
+// stored as JSON lines, one interaction per line.
+type Cassette struct {
+	Interactions []*Interaction
+}
+
+type Interaction struct {
+	RecordedAt time.Time        `json:"recorded_at"`
+	Request    RecordedRequest  `json:"request"`
+	Response   RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request without its host, so that a cassette can be replayed against
+// any api host
+type RecordedRequest struct {
+	Method  string      `json:"method"`
+	URI     string      `json:"uri"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// ReadCassette reads a cassette written in record mode
+func ReadCassette(path string) (*Cassette, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read cassette: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	cassette := &Cassette{}
+	decoder := json.NewDecoder(file)
+	for {
+		interaction := &Interaction{}
+		if err := decoder.Decode(interaction); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("could not parse cassette %s: %w", path, err)
+		}
+		cassette.Interactions = append(cassette.Interactions, interaction)
+	}
+	return cassette, nil
+}
+
+// recorder sends requests with next and appends every interaction, with credentials
+// redacted, as a line to the cassette at path, so that it is complete up to the last
+// response even if the job fails. A response is recorded when its body is closed, the
+// caller reads the body as it arrives.
+type recorder struct {
+	next    http.RoundTripper
+	path    string
+	secrets secrets
+	mu      sync.Mutex
+	file    *os.File
+}
+
+func newRecorder(next http.RoundTripper, path string, secrets ...string) (*recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("could not open cassette: %w", err)
+	}
+
+	return &recorder{next: next, path: path, secrets: newSecrets(secrets), file: file}, nil
+}
+
+// Close closes the cassette, interactions that end after it are not recorded
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close() //nolint:errcheck
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := &Interaction{
+		RecordedAt: time.Now().UTC(),
+		Request: RecordedRequest{
+			Method:  req.Method,
+			URI:     r.redact(req.URL.RequestURI()),
+			Headers: r.redactHeaders(req.Header),
+			Body:    r.redact(string(reqBody)),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Headers:    r.redactHeaders(resp.Header),
+		},
+	}
+	resp.Body = &recordedBody{body: resp.Body, recorder: r, interaction: interaction}
+
+	return resp, nil
+}
+
+// append writes interaction as a line at the end of the cassette
+func (r *recorder) append(interaction *Interaction) {
+	data, err := json.Marshal(interaction)
+	if err != nil {
+		slog.Warn("could not record interaction", "uri", interaction.Request.URI, "err", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Write(append(data, '\n')); err != nil {
+		slog.Warn("could not write cassette", "path", r.path, "err", err)
+	}
+}
+
+// recordedBody copies a response body as the caller reads it and records the interaction
+// once the body is closed. What the caller left unread is read on close, so that the
+// recorded body is complete.
+type recordedBody struct {
+	body        io.ReadCloser
+	recorder    *recorder
+	interaction *Interaction
+	copied      bytes.Buffer
+	closed      bool
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.copied.Write(p[:n])
+	return n, err
+}
+
+func (b *recordedBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	_, readErr := io.Copy(&b.copied, b.body)
+	err := b.body.Close()
+	if readErr != nil {
+		slog.Warn("could not read the rest of the response for the cassette", "uri", b.interaction.Request.URI, "err", readErr)
+	}
+	b.interaction.Response.Body = b.recorder.redact(b.copied.String())
+	b.recorder.append(b.interaction)
+
+	return err
+}
+
+func (r *recorder) redact(s string) string {
+	return r.secrets.redact(s)
+}
+
+func (r *recorder) redactHeaders(headers http.Header) http.Header {
+	clean := headers.Clone()
+	for _, name := range sensitiveHeaders {
+		if clean.Get(name) != "" {
+			clean.Set(name, redacted)
+		}
+	}
+	for name, values := range clean {
+		for i, v := range values {
+			clean[name][i] = r.redact(v)
+		}
+	}
+	return clean
+}
+
+// secrets are the credentials replaced with REDACTED in a cassette
+type secrets []string
+
+func newSecrets(values []string) secrets {
+	var s secrets
+	for _, value := range values {
+		if value != "" {
+			s = append(s, value)
+		}
+	}
+	return s
+}
+
+func (s secrets) redact(value string) string {
+	for _, secret := range s {
+		value = strings.ReplaceAll(value, secret, redacted)
+	}
+	return value
+}
+
+// replayer serves responses from a cassette instead of sending requests. Every request is
+// answered with the first unused interaction with the same method and uri, so repeated
+// requests, e.g. while waiting for files to be verified, get their responses in the recorded
+// order. The uri is redacted like a recorded one before it is compared. Request bodies are not
+// compared since they contain generated accession ids.
+type replayer struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+	secrets  secrets
+}
+
+func newReplayer(path string, secrets ...string) (*replayer, error) {
+	cassette, err := ReadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return &replayer{cassette: cassette, used: make([]bool, len(cassette.Interactions)), secrets: newSecrets(secrets)}, nil
+}
+
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body) //nolint:errcheck
+		req.Body.Close()              //nolint:errcheck
+	}
+
+	uri := r.secrets.redact(req.URL.RequestURI())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || interaction.Request.Method != req.Method || interaction.Request.URI != uri {
+			continue
+		}
+		r.used[i] = true
+
+		recorded := interaction.Response
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+			StatusCode:    recorded.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        recorded.Headers.Clone(),
+			Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+			ContentLength: int64(len(recorded.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no recorded response left for %s %s", req.Method, uri)
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/config"
+)
+
+func TestCassette(t *testing.T) {
+	token := "secret-access-token"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/testuser/files":
+			fmt.Fprint(w, `[{"inboxPath":"DATASET_ABC/a.c4gh","fileStatus":"verified"}]`)
+		case "/file/ingest":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `"file is already ingested"`)
+		}
+	}))
+
+	cassette := filepath.Join(t.TempDir(), "cassette.jsonl")
+	cfg := &config.Config{
+		ClientApiHost:      server.URL,
+		ClientAccessToken:  token,
+		UserID:             "testuser",
+		DatasetFolder:      "DATASET_ABC",
+		ClientCassette:     cassette,
+		ClientCassetteMode: CassetteRecord,
+	}
+
+	recording, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect(t, recording.UsersFiles())
+	recordedErr := recording.IngestFile("DATASET_ABC/a.c4gh", "testuser")
+	server.Close()
+
+	data, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), token) {
+		t.Error("expected the access token to be redacted from the cassette")
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("expected one line per interaction, got %d lines", lines)
+	}
+
+	cfg.ClientCassetteMode = CassetteReplay
+	replaying, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := collect(t, replaying.UsersFiles())
+	if len(files) != 1 || files[0].InboxPath != "DATASET_ABC/a.c4gh" {
+		t.Errorf("expected the recorded file listing, got %v", files)
+	}
+	if err := replaying.IngestFile("DATASET_ABC/a.c4gh", "testuser"); err == nil || err.Error() != recordedErr.Error() {
+		t.Errorf("expected the recorded error %v, got %v", recordedErr, err)
+	}
+	if err := replaying.IngestFile("DATASET_ABC/a.c4gh", "testuser"); err == nil {
+		t.Error("expected error when the cassette has no response left")
+	}
+}
+
+func TestCassetteRedactedURI(t *testing.T) {
+	token := "secret-access-token"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"inboxPath":"DATASET_ABC/a.c4gh","fileStatus":"verified"}]`)
+	}))
+	t.Cleanup(server.Close)
+
+	cassette := filepath.Join(t.TempDir(), "cassette.jsonl")
+	cfg := &config.Config{
+		ClientApiHost:      server.URL,
+		ClientAccessToken:  token,
+		UserID:             token,
+		DatasetFolder:      "DATASET_ABC",
+		ClientCassette:     cassette,
+		ClientCassetteMode: CassetteRecord,
+	}
+
+	recording, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect(t, recording.UsersFiles())
+	if err := recording.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), token) || !strings.Contains(string(data), "/users/REDACTED/files") {
+		t.Errorf("expected the access token to be redacted from the uri, got %s", data)
+	}
+
+	cfg.ClientCassetteMode = CassetteReplay
+	replaying, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := collect(t, replaying.UsersFiles()); len(files) != 1 {
+		t.Errorf("expected the request to match the redacted uri, got %v", files)
+	}
+}
@@ -2,21 +2,19 @@ package client
 
 import (
 	"bytes"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"iter"
 	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/NBISweden/submitter/internal/config"
 	"github.com/NBISweden/submitter/internal/models"
-	"github.com/cenkalti/backoff/v4"
 )
 
 type Client struct {
@@ -25,28 +23,16 @@ type Client struct {
 	userID        string
 	datasetFolder string
 	datasetID     string
+	userAgent     string
 	httpClient    *http.Client
+	retry         retryPolicy
+	retries       atomic.Int64
 }
 
 func New(cfg *config.Config) (*Client, error) {
-	httpClient := http.DefaultClient
-	if cfg.SslCaCert != "" {
-		caCert, err := os.ReadFile(cfg.SslCaCert)
-		if err != nil {
-			return nil, fmt.Errorf("init config: %w", err)
-		}
-
-		caCertPool := x509.NewCertPool()
-		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
-			return nil, fmt.Errorf("read CA cert %q: %w", cfg.SslCaCert, err)
-		}
-
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs: caCertPool,
-			},
-		}
-		httpClient = &http.Client{Transport: tr}
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	client := &Client{
@@ -55,98 +41,248 @@ func New(cfg *config.Config) (*Client, error) {
 		userID:        cfg.UserID,
 		datasetFolder: cfg.DatasetFolder,
 		datasetID:     cfg.DatasetID,
+		userAgent:     userAgent(cfg.Version),
 		httpClient:    httpClient,
+		retry:         newRetryPolicy(cfg),
 	}
 
 	return client, nil
 }
 
-func (c *Client) GetUsersFilesWithPrefix() (*http.Response, error) {
-	basePath := fmt.Sprintf("users/%s/files", c.userID)
+// UsersFiles streams the files of the user in the dataset folder, using the path_prefix filter
+// of the API. The API returns the whole listing as one JSON array, which is decoded one file at
+// a time so that large folders are never held in memory at once.
+func (c *Client) UsersFiles() iter.Seq2[models.FileInfo, error] {
+	return func(yield func(models.FileInfo, error) bool) {
+		q := url.Values{}
+		q.Set("path_prefix", c.datasetFolder)
+		resp, err := c.doRequest("GET", fmt.Sprintf("users/%s/files?%s", c.userID, q.Encode()), nil)
+		if err != nil {
+			yield(models.FileInfo{}, err)
+			return
+		}
+		defer resp.Body.Close() //nolint:errcheck
 
-	u, err := url.Parse(basePath)
+		if err := decodeFiles(resp, yield); err != nil {
+			yield(models.FileInfo{}, err)
+		}
+	}
+}
+
+// decodeFiles decodes a JSON array of files from the response and passes them to yield one at
+// a time, until yield asks to stop
+func decodeFiles(resp *http.Response, yield func(models.FileInfo, error) bool) error {
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(http.MethodGet, resp.Request.URL.Path, resp)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	token, err := decoder.Token()
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse base path: %w", err)
+		return fmt.Errorf("could not decode file listing: %w", err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("could not decode file listing: expected a list of files")
 	}
 
-	q := u.Query()
-	q.Set("path_prefix", c.datasetFolder)
-	u.RawQuery = q.Encode()
+	for decoder.More() {
+		var f models.FileInfo
+		if err := decoder.Decode(&f); err != nil {
+			return fmt.Errorf("could not decode file listing: %w", err)
+		}
+		if !yield(f, nil) {
+			return nil
+		}
+	}
 
-	return c.doRequest("GET", u.String(), nil)
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("could not decode file listing: %w", err)
+	}
+
+	return nil
 }
 
-func (c *Client) GetUsersFiles() ([]models.FileInfo, error) {
-	resp, err := c.doRequest("GET", fmt.Sprintf("users/%s/files", c.userID), nil)
-	if err != nil {
-		return nil, err
-	}
+// IngestFile asks the API to ingest the file at filepath in the inbox of user
+func (c *Client) IngestFile(filepath string, user string) error {
+	return c.post("file/ingest", map[string]string{"filepath": filepath, "user": user})
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// SetAccession assigns accessionID to the verified file at filepath. After a network error the
+// request may or may not have been applied, so the file is looked up and the request is only
+// sent again if the file is still verified without an accession id.
+func (c *Client) SetAccession(accessionID string, filepath string, user string) error {
+	payload := map[string]string{"accession_id": accessionID, "filepath": filepath, "user": user}
+	err := c.post("file/accession", payload)
+	if !isNetworkError(err) {
+		return err
 	}
-	defer resp.Body.Close()
 
-	var files []models.FileInfo
-	err = json.Unmarshal(body, &files)
-	if err != nil {
-		return nil, err
+	for f, lookupErr := range c.UsersFiles() {
+		if lookupErr != nil {
+			slog.Warn("could not look up the file after a failed accession request", "filepath", filepath, "err", lookupErr)
+			return err
+		}
+		if f.InboxPath != filepath {
+			continue
+		}
+		switch {
+		case f.AccessionID == accessionID:
+			slog.Info("accession id was assigned despite the failed request", "filepath", filepath, "accession_id", accessionID)
+			return nil
+		case f.Status == "verified" && f.AccessionID == "":
+			slog.Warn("accession request failed, sending it again", "filepath", filepath, "err", err)
+			return c.post("file/accession", payload)
+		}
+		return err
 	}
-	return files, nil
+	return err
 }
 
-func (c *Client) PostFileIngest(payload []byte) (*http.Response, error) {
-	return c.doRequest("POST", "file/ingest", payload)
+// CreateDataset adds the files with accessionIDs to the dataset, creating it if needed
+func (c *Client) CreateDataset(datasetID string, accessionIDs []string, user string) error {
+	return c.post("dataset/create", DatasetPayload{AccessionIDs: accessionIDs, DatasetID: datasetID, User: user})
 }
 
-func (c *Client) PostFileAccession(payload []byte) (*http.Response, error) {
-	return c.doRequest("POST", "file/accession", payload)
+func (c *Client) ReleaseDataset(datasetID string) error {
+	return c.post("dataset/release/"+url.PathEscape(datasetID), nil)
 }
 
-func (c *Client) PostDatasetCreate(payload []byte) (*http.Response, error) {
-	return c.doRequest("POST", "dataset/create", payload)
+func (c *Client) DeprecateDataset(datasetID string) error {
+	return c.post("dataset/deprecate/"+url.PathEscape(datasetID), nil)
 }
 
-func (c *Client) doRequest(method, path string, body []byte) (*http.Response, error) {
-	url := fmt.Sprintf("%s/%s", c.apiHost, path)
-	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+// DatasetPayload is the body of a dataset/create request
+type DatasetPayload struct {
+	AccessionIDs []string `json:"accession_ids"`
+	DatasetID    string   `json:"dataset_id"`
+	User         string   `json:"user"`
+}
+
+// post sends payload as JSON to path and returns an *APIError unless the API responds ok
+func (c *Client) post(path string, payload any) error {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.doRequest(http.MethodPost, path, body)
 	if err != nil {
-		slog.Warn("client new request err", "err", err)
-		return nil, err
+		return err
 	}
-	req.Header.Set("Authorization", "Bearer "+c.accessToken)
-	if body != nil {
-		req.Header.Set("Content-Type", "application/json")
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(http.MethodPost, path, resp)
 	}
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck
+
+	return nil
+}
+
+// idempotent reports whether sending the request twice has the same effect as sending it once.
+// A repeated ingest is answered as already ingested, while a repeated accession, dataset or
+// release request can fail or change something else when the first one was applied.
+func idempotent(method string, path string) bool {
+	return method == http.MethodGet || strings.HasPrefix(path, "file/ingest")
+}
+
+// doRequest sends the request, retrying the status codes of the retry policy, and network errors
+// of idempotent requests. The request is recreated for every attempt so that the body is sent in
+// full each time.
+func (c *Client) doRequest(method, path string, body []byte) (*http.Response, error) {
+	url := fmt.Sprintf("%s/%s", c.apiHost, path)
 	slog.Info("request", "method", method, "url", url)
 
-	var resp *http.Response
-	err = backoff.Retry(func() error {
-		resp, err = c.httpClient.Do(req)
+	start := time.Now()
+	b := c.retry.backOff()
+	for attempt := 1; ; attempt++ {
+		req, err := c.newRequest(method, url, body)
 		if err != nil {
+			slog.Warn("client new request err", "err", err)
+			return nil, err
+		}
+
+		var apiErr *APIError
+		wait, hasRetryAfter := time.Duration(0), false
+		resp, err := c.httpClient.Do(req)
+		switch {
+		case err != nil:
 			slog.Warn("client do err", "err", err)
-			return err
+			apiErr = &APIError{Method: method, Path: path, Kind: ErrUnavailable, Err: err}
+			if !idempotent(method, path) {
+				slog.Error("could not complete request, not retrying a request that may have been applied", "method", method, "url", url, "err", apiErr)
+				return nil, apiErr
+			}
+		case !c.retry.retryable(resp.StatusCode):
+			slog.Info("response", "status", resp.Status, "attempts", attempt)
+			return resp, nil
+		default:
+			wait, hasRetryAfter = retryAfter(resp, time.Now())
+			apiErr = newAPIError(method, path, resp)
+			resp.Body.Close() //nolint:errcheck
 		}
 
-		if resp.StatusCode == http.StatusInternalServerError {
-			return fmt.Errorf("non-ok response from api: %s", resp.Status)
+		if attempt >= c.retry.attempts() {
+			slog.Error("could not complete request", "method", method, "url", url, "attempts", attempt, "err", apiErr)
+			return nil, apiErr
+		}
+		if !hasRetryAfter {
+			wait = b.NextBackOff()
+		}
+		if c.retry.maxElapsed > 0 && time.Since(start)+wait > c.retry.maxElapsed {
+			slog.Error("could not complete request, giving up", "method", method, "url", url, "attempts", attempt, "max_elapsed", c.retry.maxElapsed, "err", apiErr)
+			return nil, apiErr
 		}
 
-		return nil
-	}, backoff.NewExponentialBackOff())
+		c.retries.Add(1)
+		slog.Warn("retrying request", "method", method, "url", url, "attempt", attempt, "reason", apiErr, "wait", wait)
+		time.Sleep(wait)
+	}
+}
 
+func (c *Client) newRequest(method, url string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
 	if err != nil {
-		slog.Error("could not complete request", "err", err)
 		return nil, err
 	}
 
-	slog.Info("response", "status", resp.Status)
-	return resp, nil
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	req.Header.Set("User-Agent", c.userAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// Close closes the cassette when requests are recorded, so that the file is not left open
+// until the process exits
+func (c *Client) Close() error {
+	if closer, ok := c.httpClient.Transport.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Retries returns how many requests have been retried by the client so far
+func (c *Client) Retries() int {
+	return int(c.retries.Load())
 }
 
-func (c *Client) WaitForAccession(target int, interval time.Duration, timeout time.Duration) ([]string, error) {
+// WaitForAccession polls until target files are verified. If no new files have been verified
+// for stallAfter, onStall is called with the number of files found so far, at most once every
+// stallAfter.
+func (c *Client) WaitForAccession(target int, interval time.Duration, timeout time.Duration, stallAfter time.Duration, onStall func(found int, stalledFor time.Duration)) ([]string, error) {
 	deadline := time.Now().Add(timeout)
+	lastCount := -1
+	lastChange := time.Now()
+	lastStallNotice := time.Now()
 	for {
 		paths, err := c.getVerifiedFilePaths()
 		if err != nil {
@@ -157,6 +293,14 @@ func (c *Client) WaitForAccession(target int, interval time.Duration, timeout ti
 			return paths, nil
 		}
 
+		if len(paths) > lastCount {
+			lastCount = len(paths)
+			lastChange = time.Now()
+		} else if onStall != nil && stallAfter > 0 && time.Since(lastChange) >= stallAfter && time.Since(lastStallNotice) >= stallAfter {
+			onStall(len(paths), time.Since(lastChange))
+			lastStallNotice = time.Now()
+		}
+
 		if time.Now().After(deadline) {
 			return nil, fmt.Errorf("timeout reached, only got %d/%d files", len(paths), target)
 		}
@@ -166,13 +310,11 @@ func (c *Client) WaitForAccession(target int, interval time.Duration, timeout ti
 }
 
 func (c *Client) getVerifiedFilePaths() ([]string, error) {
-	files, err := c.GetUsersFiles()
-	if err != nil {
-		return nil, err
-	}
-
 	var paths []string
-	for _, f := range files {
+	for f, err := range c.UsersFiles() {
+		if err != nil {
+			return nil, err
+		}
 		if f.Status == "verified" &&
 			strings.Contains(f.InboxPath, c.datasetFolder) &&
 			!strings.Contains(f.InboxPath, "PRIVATE") {
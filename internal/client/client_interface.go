@@ -1,13 +1,43 @@
 package client
 
 import (
-	"net/http"
+	"iter"
+	"time"
 
 	"github.com/NBISweden/submitter/internal/models"
 )
 
 type APIClient interface {
-	GetUsersFiles() ([]models.FileInfo, error)
-	PostFileIngest([]byte) (*http.Response, error)
-	PostFileAccession(payload []byte) (*http.Response, error)
+	IngestFile(filepath string, user string) error
+	SetAccession(accessionID string, filepath string, user string) error
 }
+
+// FileLister streams the files of the configured user
+type FileLister interface {
+	UsersFiles() iter.Seq2[models.FileInfo, error]
+}
+
+// DatasetClient creates datasets and changes their status
+type DatasetClient interface {
+	CreateDataset(datasetID string, accessionIDs []string, user string) error
+	ReleaseDataset(datasetID string) error
+	DeprecateDataset(datasetID string) error
+}
+
+// JobClient is everything a job needs from the SDA API, including waiting for the ingested
+// files to be verified
+type JobClient interface {
+	APIClient
+	FileLister
+	DatasetClient
+	WaitForAccession(target int, interval time.Duration, timeout time.Duration, stallAfter time.Duration, onStall func(found int, stalledFor time.Duration)) ([]string, error)
+	// Retries returns how many requests have been retried so far
+	Retries() int
+}
+
+var (
+	_ APIClient     = (*Client)(nil)
+	_ FileLister    = (*Client)(nil)
+	_ DatasetClient = (*Client)(nil)
+	_ JobClient     = (*Client)(nil)
+)
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"fmt"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+// collect reads every file from files, failing the test on an error
+func collect(t *testing.T, files iter.Seq2[models.FileInfo, error]) []models.FileInfo {
+	t.Helper()
+	var collected []models.FileInfo
+	for f, err := range files {
+		if err != nil {
+			t.Fatal(err)
+		}
+		collected = append(collected, f)
+	}
+	return collected
+}
+
+func TestUsersFiles(t *testing.T) {
+	var prefixes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users/testuser/files" {
+			http.NotFound(w, r)
+			return
+		}
+		prefixes = append(prefixes, r.URL.Query().Get("path_prefix"))
+		fmt.Fprint(w, `[{"inboxPath":"DATASET_ABC/a.c4gh","fileStatus":"verified"},{"inboxPath":"DATASET_ABC/b.c4gh","fileStatus":"uploaded"},{"inboxPath":"DATASET_ABC/c.c4gh","fileStatus":"verified"}]`)
+	}))
+	t.Cleanup(server.Close)
+
+	c, err := New(&config.Config{ClientApiHost: server.URL, UserID: "testuser", DatasetFolder: "DATASET_ABC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("All files", func(t *testing.T) {
+		prefixes = nil
+		files := collect(t, c.UsersFiles())
+		if len(files) != 3 || files[2].InboxPath != "DATASET_ABC/c.c4gh" {
+			t.Errorf("expected 3 files, got %v", files)
+		}
+		if len(prefixes) != 1 || prefixes[0] != "DATASET_ABC" {
+			t.Errorf("expected a single request with path_prefix DATASET_ABC, got %q", prefixes)
+		}
+	})
+
+	t.Run("Stop early", func(t *testing.T) {
+		var read int
+		for range c.UsersFiles() {
+			read++
+			break
+		}
+		if read != 1 {
+			t.Errorf("expected the listing to stop after the first file, read %d", read)
+		}
+	})
+
+	t.Run("Verified paths", func(t *testing.T) {
+		paths, err := c.getVerifiedFilePaths()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) != 2 {
+			t.Errorf("expected 2 verified files, got %v", paths)
+		}
+	})
+}
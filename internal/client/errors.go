@@ -0,0 +1,157 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Kinds of failures reported by the SDA API. An *APIError matches one of them with errors.Is.
+var (
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrAlreadyIngested    = errors.New("file already ingested")
+	ErrFileNotFound       = errors.New("file not found")
+	ErrNotFound           = errors.New("not found")
+	ErrDuplicateAccession = errors.New("accession id already in use")
+	ErrDatasetExists      = errors.New("dataset already exists")
+	ErrUnavailable        = errors.New("api unavailable")
+)
+
+// APIError is a request to the SDA API that failed, either with a non-ok response or because
+// the api could not be reached after all retries. Message is taken from the error body.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Message    string
+	Kind       error
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", e.Method, e.Path)
+	if e.Status != "" {
+		fmt.Fprintf(&b, " returned %s", e.Status)
+	} else {
+		b.WriteString(" failed")
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	} else if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+func (e *APIError) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// IsBenign reports whether err means the requested change had already been made, so the
+// step can carry on as if the request succeeded. A dataset that already exists is benign:
+// every chunk after the first, and --append, send the files of the same dataset again, and
+// whether they ended up in it is checked when the dataset is verified.
+func IsBenign(err error) bool {
+	return errors.Is(err, ErrAlreadyIngested) || errors.Is(err, ErrDatasetExists)
+}
+
+// IsRetryable reports whether err is a temporary failure that is worth trying again later
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrUnavailable)
+}
+
+// isNetworkError reports whether err is a request that got no response, so it is unknown
+// whether the API applied it
+func isNetworkError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == 0 && apiErr.Err != nil
+}
+
+// IsFatal reports whether err stops the whole step, e.g. because the access token was
+// rejected, rather than affecting a single file
+func IsFatal(err error) bool {
+	var apiErr *APIError
+	return err != nil && (!errors.As(err, &apiErr) || errors.Is(err, ErrUnauthorized))
+}
+
+// newAPIError decodes the error body of resp and classifies the failure
+func newAPIError(method string, path string, resp *http.Response) *APIError {
+	e := &APIError{
+		Method:     method,
+		Path:       path,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Message:    errorMessage(resp.Body),
+	}
+	e.Kind = classify(path, resp.StatusCode, e.Message)
+	return e
+}
+
+// errorMessage reads the message from an error body, which the API sends as a JSON object
+// with an error or message field, a JSON string or plain text
+func errorMessage(body io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(body, 4096))
+	data = []byte(strings.TrimSpace(string(data)))
+
+	var object map[string]any
+	if err := json.Unmarshal(data, &object); err == nil {
+		for _, key := range []string{"error", "message", "detail", "msg"} {
+			if message, ok := object[key].(string); ok && message != "" {
+				return message
+			}
+		}
+	}
+
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		return message
+	}
+
+	return string(data)
+}
+
+// Error messages of the SDA API that are told apart, they are matched exactly, ignoring case.
+// They are not shared with the fake API or the tests, which keep their own copies, so that a
+// change here shows up as a failing test.
+const (
+	messageAlreadyIngested = "file is already ingested"
+	messageFileNotFound    = "file not found"
+	messageNoRows          = "sql: no rows in result set"
+)
+
+// classify tells the kind of a failure from the endpoint, the status code and the exact error
+// message. Messages are never matched on parts, e.g. "file already has an accession id" is not
+// a duplicate accession id. Conflicts are told apart by the endpoint alone.
+func classify(path string, statusCode int, message string) error {
+	message = strings.ToLower(strings.TrimSpace(message))
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case statusCode == http.StatusTooManyRequests || statusCode >= 500:
+		return ErrUnavailable
+	case strings.HasPrefix(path, "file/ingest") && message == messageAlreadyIngested:
+		return ErrAlreadyIngested
+	case strings.HasPrefix(path, "file/accession") && statusCode == http.StatusConflict:
+		return ErrDuplicateAccession
+	case strings.HasPrefix(path, "dataset/create") && statusCode == http.StatusConflict:
+		return ErrDatasetExists
+	case statusCode == http.StatusNotFound || message == messageFileNotFound || message == messageNoRows:
+		if strings.HasPrefix(path, "file/") || strings.HasPrefix(path, "users/") {
+			return ErrFileNotFound
+		}
+		return ErrNotFound
+	}
+	return nil
+}
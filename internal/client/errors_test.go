@@ -0,0 +1,84 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/config"
+)
+
+func TestAPIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file/ingest":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `"file is already ingested"`)
+		case "/file/accession":
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"error": "accession ID already in use", "status": 409}`)
+		case "/dataset/create":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "token expired")
+		case "/dataset/release/aa-Dataset-abc":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	c, err := New(&config.Config{ClientApiHost: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, test := range map[string]struct {
+		err  error
+		kind error
+	}{
+		"Already ingested":    {c.IngestFile("DATASET_ABC/a.c4gh", "user"), ErrAlreadyIngested},
+		"Duplicate accession": {c.SetAccession("aa-File-aaaaaa-aaaaaa", "DATASET_ABC/a.c4gh", "user"), ErrDuplicateAccession},
+		"Unauthorized":        {c.CreateDataset("aa-Dataset-abc", []string{"aa-File-aaaaaa-aaaaaa"}, "user"), ErrUnauthorized},
+		"Not found":           {c.ReleaseDataset("aa-Dataset-abc"), ErrNotFound},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if !errors.Is(test.err, test.kind) {
+				t.Errorf("expected %v, got %v", test.kind, test.err)
+			}
+		})
+	}
+
+	var apiErr *APIError
+	err = c.SetAccession("aa-File-aaaaaa-aaaaaa", "DATASET_ABC/a.c4gh", "user")
+	if !errors.As(err, &apiErr) || apiErr.Message != "accession ID already in use" || apiErr.StatusCode != http.StatusConflict {
+		t.Errorf("expected the message to be decoded from the error body, got %+v", apiErr)
+	}
+	if IsBenign(err) || IsFatal(err) {
+		t.Errorf("expected a duplicate accession to be neither benign nor fatal")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for _, test := range []struct {
+		path       string
+		statusCode int
+		message    string
+		kind       error
+	}{
+		{"file/accession", http.StatusConflict, "accession ID already in use", ErrDuplicateAccession},
+		{"file/accession", http.StatusBadRequest, "file already has an accession id", nil},
+		{"file/ingest", http.StatusBadRequest, "File is already ingested", ErrAlreadyIngested},
+		{"file/ingest", http.StatusBadRequest, "file is already ingested elsewhere", nil},
+		{"dataset/create", http.StatusConflict, "dataset already exists", ErrDatasetExists},
+		{"dataset/create", http.StatusBadRequest, "dataset already exists", nil},
+		{"file/ingest", http.StatusBadRequest, "sql: no rows in result set", ErrFileNotFound},
+		{"file/accession", http.StatusBadRequest, "file not found", ErrFileNotFound},
+		{"dataset/release/aa-Dataset-abc", http.StatusNotFound, "", ErrNotFound},
+		{"dataset/create", http.StatusTooManyRequests, "", ErrUnavailable},
+	} {
+		if kind := classify(test.path, test.statusCode, test.message); kind != test.kind {
+			t.Errorf("%s %d %q: expected %v, got %v", test.path, test.statusCode, test.message, test.kind, kind)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/cenkalti/backoff/v4"
+)
+
+// retryPolicy decides which failed requests are retried and how long to wait in between.
+// The zero value makes a single attempt.
+type retryPolicy struct {
+	statusCodes     []int
+	maxAttempts     int
+	maxElapsed      time.Duration
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	jitter          float64
+}
+
+func newRetryPolicy(cfg *config.Config) retryPolicy {
+	return retryPolicy{
+		statusCodes:     cfg.ClientRetryStatusCodes,
+		maxAttempts:     cfg.ClientRetryMaxAttempts,
+		maxElapsed:      cfg.ClientRetryMaxElapsed,
+		initialInterval: cfg.ClientRetryInitialInterval,
+		maxInterval:     cfg.ClientRetryMaxInterval,
+		jitter:          cfg.ClientRetryJitter,
+	}
+}
+
+// backOff returns the exponential backoff used between attempts. The elapsed time is
+// checked by doRequest since a Retry-After header can replace the computed interval.
+func (p retryPolicy) backOff() *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	if p.initialInterval > 0 {
+		b.InitialInterval = p.initialInterval
+	}
+	if p.maxInterval > 0 {
+		b.MaxInterval = p.maxInterval
+	}
+	b.RandomizationFactor = p.jitter
+	b.MaxElapsedTime = 0
+	b.Reset()
+	return b
+}
+
+func (p retryPolicy) retryable(statusCode int) bool {
+	return slices.Contains(p.statusCodes, statusCode)
+}
+
+func (p retryPolicy) attempts() int {
+	return max(p.maxAttempts, 1)
+}
+
+// retryAfter returns the wait requested by the Retry-After header of resp, given either in
+// seconds or as an HTTP date
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,190 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NBISweden/submitter/internal/config"
+)
+
+func TestRetry(t *testing.T) {
+	var bodies []string
+	statuses := []int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		status := statuses[0]
+		statuses = statuses[1:]
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	c, err := New(&config.Config{
+		ClientApiHost:              server.URL,
+		ClientRetryStatusCodes:     []int{429, 503},
+		ClientRetryMaxAttempts:     3,
+		ClientRetryInitialInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Retried until ok", func(t *testing.T) {
+		bodies = nil
+		statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}
+		resp, err := c.doRequest(http.MethodPost, "file/ingest", []byte(`{"user":"test"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close() //nolint:errcheck
+
+		if len(bodies) != 3 {
+			t.Fatalf("expected 3 attempts, got %d", len(bodies))
+		}
+		for _, body := range bodies {
+			if body != `{"user":"test"}` {
+				t.Errorf("expected the full body on every attempt, got %q", body)
+			}
+		}
+		if c.Retries() != 2 {
+			t.Errorf("expected 2 retries, got %d", c.Retries())
+		}
+	})
+
+	t.Run("Not retryable", func(t *testing.T) {
+		bodies = nil
+		statuses = []int{http.StatusBadRequest}
+		resp, err := c.doRequest(http.MethodPost, "file/ingest", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close() //nolint:errcheck
+		if resp.StatusCode != http.StatusBadRequest || len(bodies) != 1 {
+			t.Errorf("expected a single attempt returning 400, got %s after %d attempts", resp.Status, len(bodies))
+		}
+	})
+
+	t.Run("Max attempts", func(t *testing.T) {
+		bodies = nil
+		statuses = []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}
+		if _, err := c.doRequest(http.MethodGet, "users/test/files", nil); err == nil {
+			t.Error("expected error after the last attempt")
+		}
+		if len(bodies) != 3 {
+			t.Errorf("expected 3 attempts, got %d", len(bodies))
+		}
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Duration{
+		"120":                           2 * time.Minute,
+		"Wed, 01 Jan 2025 12:00:30 GMT": 30 * time.Second,
+		"Wed, 01 Jan 2025 11:00:00 GMT": 0,
+	} {
+		resp := &http.Response{Header: http.Header{"Retry-After": {value}}}
+		wait, ok := retryAfter(resp, now)
+		if !ok || wait != expected {
+			t.Errorf("Retry-After %q: expected %s, got %s (%v)", value, expected, wait, ok)
+		}
+	}
+}
+
+func TestRetryNetworkErrors(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	drop := map[string]bool{}
+	accessionID := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests[r.Method+" "+r.URL.Path]++
+
+		switch r.URL.Path {
+		case "/users/test/files":
+			fmt.Fprintf(w, `[{"inboxPath": "DATASET_ABC/a.c4gh", "fileStatus": "verified", "accessionID": %q}]`, accessionID)
+			return
+		case "/file/accession":
+			if drop["applied"] {
+				var payload map[string]string
+				json.NewDecoder(r.Body).Decode(&payload) //nolint:errcheck
+				accessionID = payload["accession_id"]
+			}
+		}
+		if drop[r.URL.Path] {
+			drop[r.URL.Path] = false
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close() //nolint:errcheck
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	c, err := New(&config.Config{
+		ClientApiHost:              server.URL,
+		UserID:                     "test",
+		DatasetFolder:              "DATASET_ABC",
+		ClientRetryStatusCodes:     []int{503},
+		ClientRetryMaxAttempts:     3,
+		ClientRetryInitialInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Ingest is retried", func(t *testing.T) {
+		drop["/file/ingest"] = true
+		if err := c.IngestFile("DATASET_ABC/a.c4gh", "test"); err != nil {
+			t.Fatal(err)
+		}
+		if requests["POST /file/ingest"] != 2 {
+			t.Errorf("expected 2 attempts, got %d", requests["POST /file/ingest"])
+		}
+	})
+
+	t.Run("Dataset is not retried", func(t *testing.T) {
+		drop["/dataset/create"] = true
+		if err := c.CreateDataset("aa-Dataset-abc", []string{"aa-File-00000"}, "test"); err == nil {
+			t.Error("expected the dropped connection to be reported")
+		}
+		if requests["POST /dataset/create"] != 1 {
+			t.Errorf("expected a single attempt, got %d", requests["POST /dataset/create"])
+		}
+	})
+
+	t.Run("Accession applied", func(t *testing.T) {
+		requests = map[string]int{}
+		drop["/file/accession"], drop["applied"] = true, true
+		if err := c.SetAccession("aa-File-00000", "DATASET_ABC/a.c4gh", "test"); err != nil {
+			t.Fatal(err)
+		}
+		if requests["POST /file/accession"] != 1 || requests["GET /users/test/files"] != 1 {
+			t.Errorf("expected the file to be looked up instead of sending the accession again, got %v", requests)
+		}
+	})
+
+	t.Run("Accession not applied", func(t *testing.T) {
+		requests = map[string]int{}
+		accessionID = ""
+		drop["/file/accession"], drop["applied"] = true, false
+		if err := c.SetAccession("aa-File-00001", "DATASET_ABC/a.c4gh", "test"); err != nil {
+			t.Fatal(err)
+		}
+		if requests["POST /file/accession"] != 2 {
+			t.Errorf("expected the accession to be sent again for a file that is still verified, got %v", requests)
+		}
+	})
+}
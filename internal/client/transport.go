@@ -0,0 +1,114 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/NBISweden/submitter/internal/config"
+)
+
+// newHTTPClient builds the http client used for every request to the SDA API from the
+// CLIENT_* settings and SSL_CA_CERT. In cassette mode the requests are recorded to, or the
+// responses replayed from, CLIENT_CASSETTE.
+func newHTTPClient(cfg *config.Config) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.ClientProxy != "" {
+		proxyURL, err := url.Parse(cfg.ClientProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CLIENT_PROXY: %w", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: cfg.ClientRequestTimeout,
+		MaxIdleConns:          cfg.ClientMaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.ClientMaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.ClientIdleConnTimeout,
+		ForceAttemptHTTP2:     true,
+	}
+
+	var roundTripper http.RoundTripper = transport
+	switch cfg.ClientCassetteMode {
+	case CassetteRecord:
+		slog.Info("recording api requests", "cassette", cfg.ClientCassette)
+		if roundTripper, err = newRecorder(transport, cfg.ClientCassette, cfg.ClientAccessToken); err != nil {
+			return nil, err
+		}
+	case CassetteReplay:
+		slog.Info("replaying api responses", "cassette", cfg.ClientCassette)
+		if roundTripper, err = newReplayer(cfg.ClientCassette, cfg.ClientAccessToken); err != nil {
+			return nil, err
+		}
+	}
+
+	return &http.Client{Transport: roundTripper, Timeout: cfg.ClientTimeout}, nil
+}
+
+func newTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	minVersion, err := tlsVersion(cfg.ClientTLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{MinVersion: minVersion}
+
+	if cfg.SslCaCert != "" {
+		caCert, err := os.ReadFile(cfg.SslCaCert)
+		if err != nil {
+			return nil, fmt.Errorf("init config: %w", err)
+		}
+
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+			return nil, fmt.Errorf("read CA cert %q: no certificates found", cfg.SslCaCert)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func tlsVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported CLIENT_TLS_MIN_VERSION %q, use 1.2 or 1.3", version)
+	}
+}
+
+func userAgent(version string) string {
+	if version == "" {
+		version = "dev"
+	}
+	return "submitter/" + version
+}
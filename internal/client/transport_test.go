@@ -0,0 +1,59 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/config"
+)
+
+func TestTransport(t *testing.T) {
+	t.Run("User-Agent", func(t *testing.T) {
+		var agent string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			agent = r.UserAgent()
+		}))
+		t.Cleanup(server.Close)
+
+		c, err := New(&config.Config{ClientApiHost: server.URL, Version: "v1.2.3"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.doRequest(http.MethodGet, "ping", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close() //nolint:errcheck
+		if agent != "submitter/v1.2.3" {
+			t.Errorf("expected User-Agent submitter/v1.2.3, got %q", agent)
+		}
+	})
+
+	t.Run("Proxy", func(t *testing.T) {
+		var proxied string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxied = r.URL.String()
+		}))
+		t.Cleanup(proxy.Close)
+
+		c, err := New(&config.Config{ClientApiHost: "http://sda.example.org", ClientProxy: proxy.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.doRequest(http.MethodGet, "ping", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close() //nolint:errcheck
+		if proxied != "http://sda.example.org/ping" {
+			t.Errorf("expected the request to go through the proxy, got %q", proxied)
+		}
+	})
+
+	t.Run("TLS version", func(t *testing.T) {
+		if _, err := New(&config.Config{ClientTLSMinVersion: "1.0"}); err == nil {
+			t.Error("expected error for unsupported TLS version")
+		}
+	})
+}
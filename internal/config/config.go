@@ -1,39 +1,150 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	DatasetFolder     string `mapstructure:"DATASET_FOLDER"`
-	DatasetID         string `mapstructure:"DATASET_ID"`
-	UserID            string `mapstructure:"USER_ID"`
-	SslCaCert         string `mapstructure:"SSL_CA_CERT"`
-	Timeout           int    `mapstructure:"JOB_TIMEOUT"`
-	PollRate          int    `mapstructure:"JOB_POLL_RATE"`
-	ClientApiHost     string `mapstructure:"CLIENT_API_HOST"`
-	ClientAccessToken string `mapstructure:"CLIENT_ACCESS_TOKEN"`
-	DbHost            string `mapstructure:"DB_HOST"`
-	DbPort            int    `mapstructure:"DB_PORT"`
-	DbUser            string `mapstructure:"DB_USER"`
-	DbPassword        string `mapstructure:"DB_PASSWORD"`
-	DbName            string `mapstructure:"DB_NAME"`
-	DbSchema          string `mapstructure:"DB_SCHEMA"`
-	DbSslMode         string `mapstructure:"DB_SSL_MODE"`
-	DbClientCert      string `mapstructure:"DB_CLIENT_CERT"`
-	DbClientKey       string `mapstructure:"DB_CLIENT_KEY"`
-	MailAddress       string `mapstructure:"MAIL_ADDRESS"`
-	MailPassword      string `mapstructure:"MAIL_PASSWORD"`
-	MailSmtpHost      string `mapstructure:"MAIL_SMTP_HOST"`
-	MailSmtpPort      int    `mapstructure:"MAIL_SMTP_PORT"`
-	MailUploaderName  string `mapstructure:"MAIL_UPLOADER_NAME"`
-	MailUploader      string `mapstructure:"MAIL_UPLOADER"`
+	DatasetFolder              string            `mapstructure:"DATASET_FOLDER"`
+	DatasetID                  string            `mapstructure:"DATASET_ID"`
+	UserID                     string            `mapstructure:"USER_ID"`
+	SslCaCert                  string            `mapstructure:"SSL_CA_CERT"`
+	Timeout                    int               `mapstructure:"JOB_TIMEOUT"`
+	PollRate                   int               `mapstructure:"JOB_POLL_RATE"`
+	AccessionDelay             time.Duration     `mapstructure:"JOB_ACCESSION_DELAY"`
+	ClientApiHost              string            `mapstructure:"CLIENT_API_HOST"`
+	ClientAccessToken          string            `mapstructure:"CLIENT_ACCESS_TOKEN"`
+	ClientRequestTimeout       time.Duration     `mapstructure:"CLIENT_REQUEST_TIMEOUT"`
+	ClientTimeout              time.Duration     `mapstructure:"CLIENT_TIMEOUT"`
+	ClientCert                 string            `mapstructure:"CLIENT_CERT"`
+	ClientKey                  string            `mapstructure:"CLIENT_KEY"`
+	ClientProxy                string            `mapstructure:"CLIENT_PROXY"`
+	ClientTLSMinVersion        string            `mapstructure:"CLIENT_TLS_MIN_VERSION"`
+	ClientMaxIdleConns         int               `mapstructure:"CLIENT_MAX_IDLE_CONNS"`
+	ClientMaxIdleConnsPerHost  int               `mapstructure:"CLIENT_MAX_IDLE_CONNS_PER_HOST"`
+	ClientIdleConnTimeout      time.Duration     `mapstructure:"CLIENT_IDLE_CONN_TIMEOUT"`
+	ClientRetryStatusCodes     []int             `mapstructure:"CLIENT_RETRY_STATUS_CODES"`
+	ClientRetryMaxAttempts     int               `mapstructure:"CLIENT_RETRY_MAX_ATTEMPTS"`
+	ClientRetryMaxElapsed      time.Duration     `mapstructure:"CLIENT_RETRY_MAX_ELAPSED"`
+	ClientRetryInitialInterval time.Duration     `mapstructure:"CLIENT_RETRY_INITIAL_INTERVAL"`
+	ClientRetryMaxInterval     time.Duration     `mapstructure:"CLIENT_RETRY_MAX_INTERVAL"`
+	ClientRetryJitter          float64           `mapstructure:"CLIENT_RETRY_JITTER"`
+	ClientCassette             string            `mapstructure:"CLIENT_CASSETTE"`
+	ClientCassetteMode         string            `mapstructure:"CLIENT_CASSETTE_MODE"`
+	DbHost                     string            `mapstructure:"DB_HOST"`
+	DbPort                     int               `mapstructure:"DB_PORT"`
+	DbUser                     string            `mapstructure:"DB_USER"`
+	DbPassword                 string            `mapstructure:"DB_PASSWORD"`
+	DbName                     string            `mapstructure:"DB_NAME"`
+	DbSchema                   string            `mapstructure:"DB_SCHEMA"`
+	DbSslMode                  string            `mapstructure:"DB_SSL_MODE"`
+	DbClientCert               string            `mapstructure:"DB_CLIENT_CERT"`
+	DbClientKey                string            `mapstructure:"DB_CLIENT_KEY"`
+	MailAddress                string            `mapstructure:"MAIL_ADDRESS"`
+	MailPassword               string            `mapstructure:"MAIL_PASSWORD"`
+	MailSmtpHost               string            `mapstructure:"MAIL_SMTP_HOST"`
+	MailSmtpPort               int               `mapstructure:"MAIL_SMTP_PORT"`
+	MailUploaderName           string            `mapstructure:"MAIL_UPLOADER_NAME"`
+	MailUploader               string            `mapstructure:"MAIL_UPLOADER"`
+	MailTransport              string            `mapstructure:"MAIL_TRANSPORT"`
+	MailSmtpTLS                string            `mapstructure:"MAIL_SMTP_TLS"`
+	MailSmtpCaCert             string            `mapstructure:"MAIL_SMTP_CA_CERT"`
+	MailSmtpNoAuth             bool              `mapstructure:"MAIL_SMTP_NO_AUTH"`
+	MailSendmailPath           string            `mapstructure:"MAIL_SENDMAIL_PATH"`
+	MailOutputDir              string            `mapstructure:"MAIL_OUTPUT_DIR"`
+	MailTemplateDir            string            `mapstructure:"MAIL_TEMPLATE_DIR"`
+	MailRecipients             []Recipient       `mapstructure:"MAIL_RECIPIENTS"`
+	Notifiers                  []Notifier        `mapstructure:"NOTIFIERS"`
+	NotifyStallAfter           int               `mapstructure:"NOTIFY_STALL_AFTER"`
+	AlertWebhookURL            string            `mapstructure:"ALERT_WEBHOOK_URL"`
+	AlertDedupWindow           int               `mapstructure:"ALERT_DEDUP_WINDOW"`
+	ArtifactsTemplateDir       string            `mapstructure:"ARTIFACTS_TEMPLATE_DIR"`
+	ArtifactsParameters        map[string]string `mapstructure:"ARTIFACTS_PARAMETERS"`
+	MetadataXsdDir             string            `mapstructure:"METADATA_XSD_DIR"`
+	MetadataDownloadCommand    string            `mapstructure:"METADATA_DOWNLOAD_COMMAND"`
+	StructureRequiredDirs      []string          `mapstructure:"STRUCTURE_REQUIRED_DIRS"`
+	StructureAllowedDirs       []string          `mapstructure:"STRUCTURE_ALLOWED_DIRS"`
+	StructureAllowedExtensions []string          `mapstructure:"STRUCTURE_ALLOWED_EXTENSIONS"`
+	StructureAllowEmptyFiles   bool              `mapstructure:"STRUCTURE_ALLOW_EMPTY_FILES"`
+	DatasetChunkSize           int               `mapstructure:"DATASET_CHUNK_SIZE"`
+	DatasetChunkRetries        int               `mapstructure:"DATASET_CHUNK_RETRIES"`
+	RemsHost                   string            `mapstructure:"REMS_HOST"`
+	RemsApiKey                 string            `mapstructure:"REMS_API_KEY"`
+	RemsUserID                 string            `mapstructure:"REMS_USER_ID"`
+	RemsOrganization           string            `mapstructure:"REMS_ORGANIZATION"`
+	RemsWorkflowID             int               `mapstructure:"REMS_WORKFLOW_ID"`
+	RemsFormID                 int               `mapstructure:"REMS_FORM_ID"`
+	RemsLicenseIDs             []int             `mapstructure:"REMS_LICENSE_IDS"`
+	RemsTitle                  string            `mapstructure:"REMS_TITLE"`
+	RemsInfoURL                string            `mapstructure:"REMS_INFO_URL"`
+	Production                 bool              `mapstructure:"PRODUCTION"`
+	Profile                    string            `mapstructure:"-"`
+	Version                    string            `mapstructure:"-"`
 }
 
-func NewConfig(configPath string) (*Config, error) {
+// Recipient describes who gets notified by mail and with what. Addresses, subject and
+// attachments are rendered as templates with the mail template data. Relative attachment
+// paths are resolved against the data directory.
+type Recipient struct {
+	Name        string   `mapstructure:"NAME"`
+	To          []string `mapstructure:"TO"`
+	Cc          []string `mapstructure:"CC"`
+	Bcc         []string `mapstructure:"BCC"`
+	Template    string   `mapstructure:"TEMPLATE"`
+	Subject     string   `mapstructure:"SUBJECT"`
+	Attachments []string `mapstructure:"ATTACHMENTS"`
+	Events      []string `mapstructure:"EVENTS"`
+}
+
+// Notifier configures a chat or webhook notifier. URL is the webhook URL for slack and
+// webhook notifiers and the homeserver URL for matrix notifiers.
+type Notifier struct {
+	Name   string   `mapstructure:"NAME"`
+	Type   string   `mapstructure:"TYPE"`
+	URL    string   `mapstructure:"URL"`
+	Secret string   `mapstructure:"SECRET"`
+	Token  string   `mapstructure:"TOKEN"`
+	Room   string   `mapstructure:"ROOM"`
+	Events []string `mapstructure:"EVENTS"`
+}
+
+// Fingerprint identifies the configuration without revealing it: a short hash of every setting
+// except the secrets and the version, so that runs with the same settings can be recognised
+func (c *Config) Fingerprint() string {
+	fp := *c
+	fp.ClientAccessToken = ""
+	fp.DbPassword = ""
+	fp.MailPassword = ""
+	fp.RemsApiKey = ""
+	fp.Version = ""
+	fp.Notifiers = nil
+	for _, n := range c.Notifiers {
+		n.Secret, n.Token = "", ""
+		fp.Notifiers = append(fp.Notifiers, n)
+	}
+
+	data, err := json.Marshal(fp)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// NewConfig reads the configuration from configPath and the environment. If profile is not
+// empty the named section under PROFILES is layered on top of the top-level (base) keys.
+func NewConfig(configPath string, profile string) (*Config, error) {
 	v := viper.New()
 
 	v.SetConfigFile(configPath)
@@ -41,6 +152,25 @@ func NewConfig(configPath string) (*Config, error) {
 
 	v.SetDefault("JOB_TIMEOUT", 4320)
 	v.SetDefault("JOB_POLL_RATE", 180)
+	v.SetDefault("JOB_ACCESSION_DELAY", "10m")
+	v.SetDefault("CLIENT_REQUEST_TIMEOUT", "2m")
+	v.SetDefault("CLIENT_TIMEOUT", "30m")
+	v.SetDefault("CLIENT_TLS_MIN_VERSION", "1.2")
+	v.SetDefault("CLIENT_MAX_IDLE_CONNS", 100)
+	v.SetDefault("CLIENT_MAX_IDLE_CONNS_PER_HOST", 10)
+	v.SetDefault("CLIENT_IDLE_CONN_TIMEOUT", "90s")
+	v.SetDefault("CLIENT_RETRY_STATUS_CODES", []int{429, 500, 502, 503, 504})
+	v.SetDefault("CLIENT_RETRY_MAX_ATTEMPTS", 5)
+	v.SetDefault("CLIENT_RETRY_MAX_ELAPSED", "5m")
+	v.SetDefault("CLIENT_RETRY_INITIAL_INTERVAL", "1s")
+	v.SetDefault("CLIENT_RETRY_MAX_INTERVAL", "1m")
+	v.SetDefault("CLIENT_RETRY_JITTER", 0.5)
+	v.SetDefault("ALERT_DEDUP_WINDOW", 60)
+	v.SetDefault("NOTIFY_STALL_AFTER", 60)
+	v.SetDefault("DATASET_CHUNK_SIZE", 100)
+	v.SetDefault("DATASET_CHUNK_RETRIES", 3)
+	v.SetDefault("STRUCTURE_REQUIRED_DIRS", []string{"METADATA", "IMAGES", "LANDING PAGE", "PRIVATE"})
+	v.SetDefault("STRUCTURE_ALLOWED_EXTENSIONS", []string{".c4gh"})
 
 	if err := v.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
@@ -50,10 +180,22 @@ func NewConfig(configPath string) (*Config, error) {
 		}
 	}
 
+	if profile != "" {
+		if err := applyProfile(v, profile); err != nil {
+			return nil, err
+		}
+	}
+
 	cfg := &Config{}
-	if err := v.Unmarshal(cfg); err != nil {
+	decodeHook := mapstructure.ComposeDecodeHookFunc(
+		jsonStringHook(),
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	)
+	if err := v.Unmarshal(cfg, viper.DecodeHook(decodeHook)); err != nil {
 		return nil, fmt.Errorf("could not unmarshal config: %w", err)
 	}
+	cfg.Profile = profile
 
 	if err := validateConfig(cfg); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -62,6 +204,69 @@ func NewConfig(configPath string) (*Config, error) {
 	return cfg, nil
 }
 
+// applyProfile merges the settings of the named profile, and any profiles it inherits
+// from through INHERITS, over the base configuration. Environment variables still take
+// priority over values from a profile.
+func applyProfile(v *viper.Viper, profile string) error {
+	var chain []map[string]any
+	seen := map[string]bool{}
+	for name := profile; name != ""; {
+		if seen[name] {
+			return fmt.Errorf("profile %q has a circular INHERITS chain", profile)
+		}
+		seen[name] = true
+
+		settings := v.GetStringMap("PROFILES." + name)
+		if len(settings) == 0 {
+			return fmt.Errorf("profile %q not found in configuration", name)
+		}
+		chain = append(chain, settings)
+		name = v.GetString("PROFILES." + name + ".INHERITS")
+	}
+
+	// Apply the most distant ancestor first so that the selected profile wins
+	for i := len(chain) - 1; i >= 0; i-- {
+		if err := v.MergeConfigMap(chain[i]); err != nil {
+			return fmt.Errorf("could not apply profile %q: %w", profile, err)
+		}
+	}
+	slog.Info("using configuration profile", "profile", profile)
+
+	return nil
+}
+
+// jsonStringHook allows list and map valued keys such as MAIL_RECIPIENTS to be supplied as a
+// JSON string, which is the only way to set them through environment variables
+func jsonStringHook() mapstructure.DecodeHookFuncType {
+	return func(f reflect.Type, t reflect.Type, data any) (any, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+
+		switch {
+		case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct:
+			var raw []map[string]any
+			if err := json.Unmarshal([]byte(data.(string)), &raw); err != nil {
+				return nil, fmt.Errorf("could not parse JSON list: %w", err)
+			}
+			return raw, nil
+		case t.Kind() == reflect.Map:
+			var raw map[string]any
+			if err := json.Unmarshal([]byte(data.(string)), &raw); err != nil {
+				return nil, fmt.Errorf("could not parse JSON object: %w", err)
+			}
+			// Keys from the config file are lower cased by viper, do the same for consistency
+			lowered := make(map[string]any, len(raw))
+			for k, v := range raw {
+				lowered[strings.ToLower(k)] = v
+			}
+			return lowered, nil
+		}
+
+		return data, nil
+	}
+}
+
 func bindKeys(v *viper.Viper) {
 	v.BindEnv("DATASET_FOLDER")
 	v.BindEnv("DATASET_ID")
@@ -69,8 +274,26 @@ func bindKeys(v *viper.Viper) {
 	v.BindEnv("SSL_CA_CERT")
 	v.BindEnv("JOB_TIMEOUT")
 	v.BindEnv("JOB_POLL_RATE")
+	v.BindEnv("JOB_ACCESSION_DELAY")
 	v.BindEnv("CLIENT_API_HOST")
 	v.BindEnv("CLIENT_ACCESS_TOKEN")
+	v.BindEnv("CLIENT_REQUEST_TIMEOUT")
+	v.BindEnv("CLIENT_TIMEOUT")
+	v.BindEnv("CLIENT_CERT")
+	v.BindEnv("CLIENT_KEY")
+	v.BindEnv("CLIENT_PROXY")
+	v.BindEnv("CLIENT_TLS_MIN_VERSION")
+	v.BindEnv("CLIENT_MAX_IDLE_CONNS")
+	v.BindEnv("CLIENT_MAX_IDLE_CONNS_PER_HOST")
+	v.BindEnv("CLIENT_IDLE_CONN_TIMEOUT")
+	v.BindEnv("CLIENT_RETRY_STATUS_CODES")
+	v.BindEnv("CLIENT_RETRY_MAX_ATTEMPTS")
+	v.BindEnv("CLIENT_RETRY_MAX_ELAPSED")
+	v.BindEnv("CLIENT_RETRY_INITIAL_INTERVAL")
+	v.BindEnv("CLIENT_RETRY_MAX_INTERVAL")
+	v.BindEnv("CLIENT_RETRY_JITTER")
+	v.BindEnv("CLIENT_CASSETTE")
+	v.BindEnv("CLIENT_CASSETTE_MODE")
 	v.BindEnv("DB_HOST")
 	v.BindEnv("DB_PORT")
 	v.BindEnv("DB_USER")
@@ -86,6 +309,38 @@ func bindKeys(v *viper.Viper) {
 	v.BindEnv("MAIL_SMTP_PORT")
 	v.BindEnv("MAIL_UPLOADER_NAME")
 	v.BindEnv("MAIL_UPLOADER")
+	v.BindEnv("MAIL_TRANSPORT")
+	v.BindEnv("MAIL_SMTP_TLS")
+	v.BindEnv("MAIL_SMTP_CA_CERT")
+	v.BindEnv("MAIL_SMTP_NO_AUTH")
+	v.BindEnv("MAIL_SENDMAIL_PATH")
+	v.BindEnv("MAIL_OUTPUT_DIR")
+	v.BindEnv("MAIL_TEMPLATE_DIR")
+	v.BindEnv("MAIL_RECIPIENTS")
+	v.BindEnv("NOTIFIERS")
+	v.BindEnv("NOTIFY_STALL_AFTER")
+	v.BindEnv("ALERT_WEBHOOK_URL")
+	v.BindEnv("ALERT_DEDUP_WINDOW")
+	v.BindEnv("ARTIFACTS_TEMPLATE_DIR")
+	v.BindEnv("ARTIFACTS_PARAMETERS")
+	v.BindEnv("METADATA_XSD_DIR")
+	v.BindEnv("METADATA_DOWNLOAD_COMMAND")
+	v.BindEnv("STRUCTURE_REQUIRED_DIRS")
+	v.BindEnv("STRUCTURE_ALLOWED_DIRS")
+	v.BindEnv("STRUCTURE_ALLOWED_EXTENSIONS")
+	v.BindEnv("STRUCTURE_ALLOW_EMPTY_FILES")
+	v.BindEnv("DATASET_CHUNK_SIZE")
+	v.BindEnv("DATASET_CHUNK_RETRIES")
+	v.BindEnv("REMS_HOST")
+	v.BindEnv("REMS_API_KEY")
+	v.BindEnv("REMS_USER_ID")
+	v.BindEnv("REMS_ORGANIZATION")
+	v.BindEnv("REMS_WORKFLOW_ID")
+	v.BindEnv("REMS_FORM_ID")
+	v.BindEnv("REMS_LICENSE_IDS")
+	v.BindEnv("REMS_TITLE")
+	v.BindEnv("REMS_INFO_URL")
+	v.BindEnv("PRODUCTION")
 }
 
 func validateConfig(cfg *Config) error {
@@ -101,6 +356,63 @@ func validateConfig(cfg *Config) error {
 		return fmt.Errorf("USER_ID requiered")
 	}
 
+	for i, r := range cfg.MailRecipients {
+		if r.Name == "" {
+			return fmt.Errorf("MAIL_RECIPIENTS entry %d is missing NAME", i)
+		}
+		if len(r.To) == 0 {
+			return fmt.Errorf("MAIL_RECIPIENTS entry %s is missing TO", r.Name)
+		}
+		if r.Template == "" {
+			return fmt.Errorf("MAIL_RECIPIENTS entry %s is missing TEMPLATE", r.Name)
+		}
+	}
+
+	for i, n := range cfg.Notifiers {
+		if n.Name == "" {
+			return fmt.Errorf("NOTIFIERS entry %d is missing NAME", i)
+		}
+		if n.URL == "" {
+			return fmt.Errorf("NOTIFIERS entry %s is missing URL", n.Name)
+		}
+		if n.Type == "matrix" && (n.Room == "" || n.Token == "") {
+			return fmt.Errorf("NOTIFIERS entry %s requires ROOM and TOKEN", n.Name)
+		}
+	}
+
+	// net/smtp only sends credentials over an unencrypted connection to localhost, any other
+	// host would fail when the first mail is sent
+	if (cfg.MailTransport == "" || cfg.MailTransport == "smtp") && cfg.MailSmtpTLS == "none" && !cfg.MailSmtpNoAuth &&
+		!slices.Contains([]string{"localhost", "127.0.0.1", "::1"}, cfg.MailSmtpHost) {
+		return fmt.Errorf("MAIL_SMTP_TLS none requires MAIL_SMTP_NO_AUTH unless MAIL_SMTP_HOST is localhost, credentials are not sent over an unencrypted connection")
+	}
+
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		return fmt.Errorf("CLIENT_CERT and CLIENT_KEY must be set together")
+	}
+
+	if cfg.ClientRetryMaxAttempts < 1 {
+		return fmt.Errorf("CLIENT_RETRY_MAX_ATTEMPTS must be at least 1")
+	}
+
+	if cfg.ClientRetryJitter < 0 || cfg.ClientRetryJitter > 1 {
+		return fmt.Errorf("CLIENT_RETRY_JITTER must be between 0 and 1")
+	}
+
+	switch cfg.ClientCassetteMode {
+	case "":
+	case "record", "replay":
+		if cfg.ClientCassette == "" {
+			return fmt.Errorf("CLIENT_CASSETTE is required with CLIENT_CASSETTE_MODE %s", cfg.ClientCassetteMode)
+		}
+	default:
+		return fmt.Errorf("unknown CLIENT_CASSETTE_MODE %q, use record or replay", cfg.ClientCassetteMode)
+	}
+
+	if cfg.DatasetChunkSize < 1 {
+		return fmt.Errorf("DATASET_CHUNK_SIZE must be at least 1")
+	}
+
 	if cfg.PollRate > cfg.Timeout {
 		return fmt.Errorf("JOB_POLL_RATE greater than JOB_TIMEOUT, set a pollrate that is less than the timeout value")
 	}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const profilesConfig = `
+USER_ID: "user-1234"
+DATASET_ID: "aa-Dataset-abc"
+DATASET_FOLDER: "DATASET_ABC"
+CLIENT_API_HOST: "https://api.test.example.com"
+DB_HOST: "db.test.example.com"
+
+PROFILES:
+  staging:
+    CLIENT_API_HOST: "https://api.staging.example.com"
+    DB_HOST: "db.staging.example.com"
+  production:
+    INHERITS: staging
+    PRODUCTION: true
+    CLIENT_API_HOST: "https://api.example.com"
+  loop:
+    INHERITS: loop
+`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProfiles(t *testing.T) {
+	path := writeConfig(t, profilesConfig)
+
+	t.Run("Base config without profile", func(t *testing.T) {
+		cfg, err := NewConfig(path, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ClientApiHost != "https://api.test.example.com" || cfg.Production {
+			t.Errorf("unexpected base config: %+v", cfg)
+		}
+	})
+
+	t.Run("Profile inherits from base and parent profile", func(t *testing.T) {
+		cfg, err := NewConfig(path, "production")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ClientApiHost != "https://api.example.com" {
+			t.Errorf("expected production api host, got %s", cfg.ClientApiHost)
+		}
+		if cfg.DbHost != "db.staging.example.com" {
+			t.Errorf("expected db host inherited from staging, got %s", cfg.DbHost)
+		}
+		if cfg.DatasetID != "aa-Dataset-abc" {
+			t.Errorf("expected dataset id from base, got %s", cfg.DatasetID)
+		}
+		if !cfg.Production || cfg.Profile != "production" {
+			t.Errorf("expected production profile to be marked, got %+v", cfg)
+		}
+	})
+
+	t.Run("Unknown profile", func(t *testing.T) {
+		if _, err := NewConfig(path, "missing"); err == nil {
+			t.Error("expected error for unknown profile")
+		}
+	})
+
+	t.Run("Circular inheritance", func(t *testing.T) {
+		if _, err := NewConfig(path, "loop"); err == nil {
+			t.Error("expected error for circular profile inheritance")
+		}
+	})
+}
+
+func TestRecipientsFromEnvironment(t *testing.T) {
+	path := writeConfig(t, profilesConfig)
+	t.Setenv("MAIL_RECIPIENTS", `[{"NAME": "Submitter", "TO": ["{{.UploaderEmail}}"], "TEMPLATE": "notify-submitter.html", "EVENTS": ["job_finished"]}]`)
+
+	cfg, err := NewConfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cfg.MailRecipients) != 1 {
+		t.Fatalf("expected 1 recipient, got %d", len(cfg.MailRecipients))
+	}
+
+	r := cfg.MailRecipients[0]
+	if r.Name != "Submitter" || r.To[0] != "{{.UploaderEmail}}" || r.Template != "notify-submitter.html" {
+		t.Errorf("unexpected recipient: %+v", r)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	cfg := &Config{DatasetID: "aa-Dataset-abc", ClientAccessToken: "token", Notifiers: []Notifier{{Name: "chat", Secret: "shared"}}}
+	fingerprint := cfg.Fingerprint()
+
+	rotated := *cfg
+	rotated.ClientAccessToken = "rotated"
+	rotated.Notifiers = []Notifier{{Name: "chat", Secret: "rotated"}}
+	rotated.Version = "v2.0.0"
+	if rotated.Fingerprint() != fingerprint {
+		t.Error("expected secrets and the version to be left out of the fingerprint")
+	}
+
+	other := *cfg
+	other.DatasetID = "aa-Dataset-other"
+	if other.Fingerprint() == fingerprint {
+		t.Error("expected a different fingerprint for a different dataset")
+	}
+	if cfg.Notifiers[0].Secret != "shared" {
+		t.Error("expected the configuration not to be modified")
+	}
+}
+
+func TestValidateSmtpAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		tls     string
+		noAuth  bool
+		wantErr bool
+	}{
+		{"StartTLS", "smtp.example.com", "starttls", false, false},
+		{"Unencrypted with auth", "smtp.example.com", "none", false, true},
+		{"Unencrypted without auth", "smtp.example.com", "none", true, false},
+		{"Unencrypted to localhost", "localhost", "none", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				DatasetFolder:          "DATASET_ABC",
+				DatasetID:              "aa-Dataset-abc",
+				UserID:                 "user",
+				ClientRetryMaxAttempts: 1,
+				DatasetChunkSize:       1,
+				MailSmtpHost:           tt.host,
+				MailSmtpTLS:            tt.tls,
+				MailSmtpNoAuth:         tt.noAuth,
+			}
+			if err := validateConfig(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig() = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,25 +2,91 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"iter"
+	"strings"
 
 	"github.com/NBISweden/submitter/internal/models"
 	"github.com/cenkalti/backoff/v4"
+	"github.com/lib/pq"
 )
 
-func (dbs *PostgresDb) GetUserFiles(userID, pathPrefix string, allData bool) ([]models.FileInfo, error) {
+// UserFiles streams the files of the user under pathPrefix that are not part of a dataset and
+// not disabled, ordered by path. If statuses is not empty only files whose latest event is one
+// of statuses are returned. Accession ids are only included when allData is set.
+func (dbs *PostgresDb) UserFiles(userID, pathPrefix string, statuses []string, allData bool) iter.Seq2[models.FileInfo, error] {
+	return func(yield func(models.FileInfo, error) bool) {
+		db := dbs.db
+
+		const query = `SELECT f.id, f.submission_file_path, f.stable_id, e.event, f.created_at, f.submission_file_size FROM sda.files f
+LEFT JOIN LATERAL (SELECT event FROM sda.file_event_log l WHERE l.file_id = f.id ORDER BY l.started_at DESC LIMIT 1) e ON true
+WHERE f.submission_user = $1 AND f.submission_file_path LIKE $2 ESCAPE '\'
+AND e.event IS DISTINCT FROM 'disabled'
+AND (coalesce(cardinality($3::text[]), 0) = 0 OR e.event = ANY($3::text[]))
+AND NOT EXISTS (SELECT 1 FROM sda.file_dataset d WHERE f.id = d.file_id)
+ORDER BY f.submission_file_path;`
+
+		var rows *sql.Rows
+		err := backoff.Retry(func() error {
+			var err error
+			rows, err = db.Query(query, userID, likePrefix(pathPrefix), pq.Array(statuses))
+			return err
+		}, backoff.NewExponentialBackOff())
+		if err != nil {
+			yield(models.FileInfo{}, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var accessionID sql.NullString
+			var status sql.NullString
+			var size sql.NullInt64
+			fi := models.FileInfo{}
+			if err := rows.Scan(&fi.FileID, &fi.InboxPath, &accessionID, &status, &fi.CreateAt, &size); err != nil {
+				yield(models.FileInfo{}, err)
+				return
+			}
+			fi.Status = status.String
+			fi.Size = size.Int64
+
+			if allData {
+				fi.AccessionID = accessionID.String
+			}
+
+			if !yield(fi, nil) {
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			yield(models.FileInfo{}, err)
+		}
+	}
+}
+
+// likePrefix returns a LIKE pattern matching everything starting with prefix, the LIKE
+// wildcards in prefix, e.g. the _ in DATASET_ABC, are matched literally
+func likePrefix(prefix string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	return escaped + "%"
+}
+
+// GetDatasetFiles returns the files that are part of the dataset with the given stable id
+func (dbs *PostgresDb) GetDatasetFiles(datasetID string) ([]models.FileInfo, error) {
 	files := []models.FileInfo{}
 	db := dbs.db
 
-	const query = `SELECT f.id, f.submission_file_path, f.stable_id, e.event, f.created_at FROM sda.files f
-LEFT JOIN (SELECT DISTINCT ON (file_id) file_id, started_at, event FROM sda.file_event_log ORDER BY file_id, started_at DESC) e ON f.id = e.file_id
-WHERE f.submission_user = $1 and f.submission_file_path LIKE $2
-AND NOT EXISTS (SELECT 1 FROM sda.file_dataset d WHERE f.id = d.file_id);`
+	const query = `SELECT f.id, f.submission_file_path, f.stable_id, f.created_at, f.submission_file_size FROM sda.files f
+JOIN sda.file_dataset fd ON f.id = fd.file_id
+JOIN sda.datasets d ON fd.dataset_id = d.id
+WHERE d.stable_id = $1;`
 
 	var rows *sql.Rows
 	err := backoff.Retry(func() error {
 		var err error
-		rows, err = db.Query(query, userID, fmt.Sprintf("%s%%", pathPrefix))
+		rows, err = db.Query(query, datasetID)
 		return err
 	}, backoff.NewExponentialBackOff())
 	if err != nil {
@@ -30,20 +96,74 @@ AND NOT EXISTS (SELECT 1 FROM sda.file_dataset d WHERE f.id = d.file_id);`
 
 	for rows.Next() {
 		var accessionID sql.NullString
+		var size sql.NullInt64
 		fi := models.FileInfo{}
-		err := rows.Scan(&fi.FileID, &fi.InboxPath, &accessionID, &fi.Status, &fi.CreateAt)
+		err := rows.Scan(&fi.FileID, &fi.InboxPath, &accessionID, &fi.CreateAt, &size)
 		if err != nil {
 			return nil, err
 		}
+		fi.AccessionID = accessionID.String
+		fi.Size = size.Int64
+		files = append(files, fi)
+	}
+
+	return files, rows.Err()
+}
 
-		if allData {
-			fi.AccessionID = accessionID.String
+// GetDatasetStatus returns the latest event for the dataset in sda.dataset_event_log, e.g.
+// registered, released or deprecated
+func (dbs *PostgresDb) GetDatasetStatus(datasetID string) (string, error) {
+	db := dbs.db
+
+	const query = `SELECT event FROM sda.dataset_event_log WHERE dataset_id = $1 ORDER BY event_date DESC LIMIT 1;`
+
+	var status string
+	err := backoff.Retry(func() error {
+		err := db.QueryRow(query, datasetID).Scan(&status)
+		if errors.Is(err, sql.ErrNoRows) {
+			return backoff.Permanent(err)
 		}
+		return err
+	}, backoff.NewExponentialBackOff())
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("dataset %s not found in dataset_event_log", datasetID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return status, nil
+}
 
-		if fi.Status != "disabled" {
-			files = append(files, fi)
+// GetFilesByStableIDs returns the files with one of the stable ids, ordered by path
+func (dbs *PostgresDb) GetFilesByStableIDs(stableIDs []string) ([]models.FileInfo, error) {
+	files := []models.FileInfo{}
+	db := dbs.db
+
+	const query = `SELECT f.id, f.submission_file_path, f.stable_id, f.created_at, f.submission_file_size FROM sda.files f
+WHERE f.stable_id = ANY($1::text[])
+ORDER BY f.submission_file_path;`
+
+	var rows *sql.Rows
+	err := backoff.Retry(func() error {
+		var err error
+		rows, err = db.Query(query, pq.Array(stableIDs))
+		return err
+	}, backoff.NewExponentialBackOff())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var size sql.NullInt64
+		fi := models.FileInfo{}
+		if err := rows.Scan(&fi.FileID, &fi.InboxPath, &fi.AccessionID, &fi.CreateAt, &size); err != nil {
+			return nil, err
 		}
+		fi.Size = size.Int64
+		files = append(files, fi)
 	}
 
-	return files, nil
+	return files, rows.Err()
 }
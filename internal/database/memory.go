@@ -0,0 +1,203 @@
+package database
+
+import (
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+type memoryFile struct {
+	userID  string
+	info    models.FileInfo
+	dataset string
+}
+
+type memoryDataset struct {
+	events  []string
+	fileIDs []string
+}
+
+// Memory is an in-memory Repository with the same filtering as PostgresDb. The zero value is
+// not usable, create it with NewMemory.
+type Memory struct {
+	mu       sync.Mutex
+	files    []*memoryFile
+	datasets map[string]*memoryDataset
+}
+
+// NewMemory returns an empty in-memory repository
+func NewMemory() *Memory {
+	return &Memory{datasets: map[string]*memoryDataset{}}
+}
+
+// AddFile adds a file uploaded by userID, fi.Status is its latest event and fi.AccessionID its
+// stable id. A missing FileID is generated.
+func (m *Memory) AddFile(userID string, fi models.FileInfo) models.FileInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if fi.FileID == "" {
+		fi.FileID = fmt.Sprintf("file-%05d", len(m.files)+1)
+	}
+	m.files = append(m.files, &memoryFile{userID: userID, info: fi})
+	return fi
+}
+
+// LogFileEvent records event as the latest event of the file with fileID
+func (m *Memory) LogFileEvent(fileID string, event string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.file(fileID)
+	if f == nil {
+		return fmt.Errorf("file %s not found", fileID)
+	}
+	f.info.Status = event
+	return nil
+}
+
+// SetStableID sets the stable id of the file with fileID
+func (m *Memory) SetStableID(fileID string, stableID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.file(fileID)
+	if f == nil {
+		return fmt.Errorf("file %s not found", fileID)
+	}
+	f.info.AccessionID = stableID
+	return nil
+}
+
+// AddDatasetFiles adds the files with fileIDs to the dataset with the given stable id, the
+// dataset is registered when it does not exist yet
+func (m *Memory) AddDatasetFiles(datasetID string, fileIDs ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range fileIDs {
+		f := m.file(id)
+		if f == nil {
+			return fmt.Errorf("file %s not found", id)
+		}
+		if f.dataset != "" && f.dataset != datasetID {
+			return fmt.Errorf("file %s is already part of dataset %s", id, f.dataset)
+		}
+	}
+
+	d, ok := m.datasets[datasetID]
+	if !ok {
+		d = &memoryDataset{events: []string{"registered"}}
+		m.datasets[datasetID] = d
+	}
+	for _, id := range fileIDs {
+		m.file(id).dataset = datasetID
+		if !slices.Contains(d.fileIDs, id) {
+			d.fileIDs = append(d.fileIDs, id)
+		}
+	}
+	return nil
+}
+
+// LogDatasetEvent records event, e.g. released, as the latest event of the dataset
+func (m *Memory) LogDatasetEvent(datasetID string, event string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.datasets[datasetID]
+	if !ok {
+		return fmt.Errorf("dataset %s not found", datasetID)
+	}
+	d.events = append(d.events, event)
+	return nil
+}
+
+func (m *Memory) UserFiles(userID, pathPrefix string, statuses []string, allData bool) iter.Seq2[models.FileInfo, error] {
+	return func(yield func(models.FileInfo, error) bool) {
+		m.mu.Lock()
+		var files []models.FileInfo
+		for _, f := range m.files {
+			if f.userID != userID || !strings.HasPrefix(f.info.InboxPath, pathPrefix) || f.dataset != "" {
+				continue
+			}
+			if f.info.Status == "disabled" || (len(statuses) > 0 && !slices.Contains(statuses, f.info.Status)) {
+				continue
+			}
+			fi := f.info
+			if !allData {
+				fi.AccessionID = ""
+			}
+			files = append(files, fi)
+		}
+		m.mu.Unlock()
+
+		slices.SortStableFunc(files, func(a, b models.FileInfo) int {
+			return strings.Compare(a.InboxPath, b.InboxPath)
+		})
+		for _, fi := range files {
+			if !yield(fi, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (m *Memory) GetDatasetFiles(datasetID string) ([]models.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := []models.FileInfo{}
+	if d, ok := m.datasets[datasetID]; ok {
+		for _, id := range d.fileIDs {
+			fi := m.file(id).info
+			fi.Status = ""
+			files = append(files, fi)
+		}
+	}
+	return files, nil
+}
+
+func (m *Memory) GetDatasetStatus(datasetID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.datasets[datasetID]
+	if !ok {
+		return "", fmt.Errorf("dataset %s not found in dataset_event_log", datasetID)
+	}
+	return d.events[len(d.events)-1], nil
+}
+
+func (m *Memory) GetFilesByStableIDs(stableIDs []string) ([]models.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := []models.FileInfo{}
+	for _, f := range m.files {
+		if f.info.AccessionID != "" && slices.Contains(stableIDs, f.info.AccessionID) {
+			fi := f.info
+			fi.Status = ""
+			files = append(files, fi)
+		}
+	}
+	slices.SortStableFunc(files, func(a, b models.FileInfo) int {
+		return strings.Compare(a.InboxPath, b.InboxPath)
+	})
+	return files, nil
+}
+
+// Close does nothing, it is there to satisfy Repository
+func (m *Memory) Close() {}
+
+func (m *Memory) file(fileID string) *memoryFile {
+	for _, f := range m.files {
+		if f.info.FileID == fileID {
+			return f
+		}
+	}
+	return nil
+}
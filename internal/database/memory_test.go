@@ -0,0 +1,81 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+func TestMemoryUserFiles(t *testing.T) {
+	m := NewMemory()
+	b := m.AddFile("user", models.FileInfo{InboxPath: "DATASET_ABC/b.c4gh", Status: "verified", AccessionID: "aa-File-bbbbbb-bbbbbb"})
+	a := m.AddFile("user", models.FileInfo{InboxPath: "DATASET_ABC/a.c4gh", Status: "uploaded"})
+	m.AddFile("user", models.FileInfo{InboxPath: "DATASET_ABC/c.c4gh", Status: "disabled"})
+	m.AddFile("user", models.FileInfo{InboxPath: "OTHER/d.c4gh", Status: "uploaded"})
+	m.AddFile("other", models.FileInfo{InboxPath: "DATASET_ABC/e.c4gh", Status: "uploaded"})
+	in := m.AddFile("user", models.FileInfo{InboxPath: "DATASET_ABC/f.c4gh", Status: "ready", AccessionID: "aa-File-ffffff-ffffff"})
+	if err := m.AddDatasetFiles("aa-Dataset-abc", in.FileID); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []models.FileInfo
+	for f, err := range m.UserFiles("user", "DATASET_ABC", nil, false) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	if len(files) != 2 || files[0].FileID != a.FileID || files[1].FileID != b.FileID {
+		t.Fatalf("expected a.c4gh and b.c4gh, got %+v", files)
+	}
+	if files[1].AccessionID != "" {
+		t.Errorf("expected no stable id without allData, got %q", files[1].AccessionID)
+	}
+
+	if err := m.LogFileEvent(a.FileID, "verified"); err != nil {
+		t.Fatal(err)
+	}
+	var verified []models.FileInfo
+	for f, err := range m.UserFiles("user", "DATASET_ABC", []string{"verified"}, true) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		verified = append(verified, f)
+	}
+	if len(verified) != 2 || verified[1].AccessionID != "aa-File-bbbbbb-bbbbbb" {
+		t.Errorf("expected 2 verified files with stable ids, got %+v", verified)
+	}
+
+	byStableID, err := m.GetFilesByStableIDs([]string{"aa-File-ffffff-ffffff", "aa-File-bbbbbb-bbbbbb", "aa-File-unknown"})
+	if err != nil || len(byStableID) != 2 || byStableID[0].FileID != b.FileID || byStableID[1].FileID != in.FileID {
+		t.Errorf("expected b.c4gh and f.c4gh by their stable ids, got %+v, %v", byStableID, err)
+	}
+}
+
+func TestMemoryDataset(t *testing.T) {
+	m := NewMemory()
+	f := m.AddFile("user", models.FileInfo{InboxPath: "DATASET_ABC/a.c4gh", Status: "ready"})
+
+	if _, err := m.GetDatasetStatus("aa-Dataset-abc"); err == nil {
+		t.Error("expected an error for a dataset that does not exist")
+	}
+
+	if err := m.AddDatasetFiles("aa-Dataset-abc", f.FileID); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddDatasetFiles("aa-Dataset-other", f.FileID); err == nil {
+		t.Error("expected an error when adding a file to a second dataset")
+	}
+	if err := m.LogDatasetEvent("aa-Dataset-abc", "released"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := m.GetDatasetStatus("aa-Dataset-abc")
+	if err != nil || status != "released" {
+		t.Errorf("expected released, got %q, %v", status, err)
+	}
+	files, err := m.GetDatasetFiles("aa-Dataset-abc")
+	if err != nil || len(files) != 1 || files[0].FileID != f.FileID {
+		t.Errorf("expected the dataset to contain %s, got %+v, %v", f.FileID, files, err)
+	}
+}
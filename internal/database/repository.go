@@ -0,0 +1,31 @@
+package database
+
+import (
+	"iter"
+
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+// Repository is the read access to the sda schema the submitter needs: the files of a user
+// under a path prefix with the latest status from the file event log and their stable ids, the
+// files with given stable ids, and the files and status of a dataset. PostgresDb reads them from the database, Memory keeps them
+// in memory for tests and rehearsals.
+type Repository interface {
+	// UserFiles streams the files of the user under pathPrefix that are not part of a dataset
+	// and not disabled, ordered by path. If statuses is not empty only files whose latest
+	// event is one of statuses are returned. Stable ids are only included when allData is set.
+	UserFiles(userID, pathPrefix string, statuses []string, allData bool) iter.Seq2[models.FileInfo, error]
+	// GetDatasetFiles returns the files of the dataset with the given stable id
+	GetDatasetFiles(datasetID string) ([]models.FileInfo, error)
+	// GetDatasetStatus returns the latest event of the dataset, e.g. registered
+	GetDatasetStatus(datasetID string) (string, error)
+	// GetFilesByStableIDs returns the files that have one of the stable ids, ordered by path.
+	// Stable ids that no file has yet are left out.
+	GetFilesByStableIDs(stableIDs []string) ([]models.FileInfo, error)
+	Close()
+}
+
+var (
+	_ Repository = (*PostgresDb)(nil)
+	_ Repository = (*Memory)(nil)
+)
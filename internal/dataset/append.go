@@ -0,0 +1,31 @@
+package dataset
+
+import (
+	"strings"
+
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+// NewFiles returns the files in candidates that can be appended to a dataset with members.
+// Candidates are files in the dataset folder that are not part of any dataset yet, files in
+// PRIVATE and LANDING PAGE are never part of a dataset and are left out. A candidate with the
+// same path as a member is a re-upload of that file and is returned in conflicts instead.
+func NewFiles(candidates []models.FileInfo, members []models.FileInfo) (added []models.FileInfo, conflicts []models.FileInfo) {
+	existing := map[string]bool{}
+	for _, f := range members {
+		existing[f.InboxPath] = true
+	}
+
+	for _, f := range candidates {
+		if f.Status == "disabled" || strings.Contains(f.InboxPath, "PRIVATE") || strings.Contains(f.InboxPath, "LANDING PAGE") {
+			continue
+		}
+		if existing[f.InboxPath] {
+			conflicts = append(conflicts, f)
+			continue
+		}
+		added = append(added, f)
+	}
+
+	return added, conflicts
+}
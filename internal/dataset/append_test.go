@@ -0,0 +1,28 @@
+package dataset
+
+import (
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+func TestNewFiles(t *testing.T) {
+	members := []models.FileInfo{
+		{InboxPath: "DATASET_ABC/IMAGES/image-1.dcm.c4gh", AccessionID: "aa-File-aaaaaa-aaaaaa"},
+		{InboxPath: "DATASET_ABC/IMAGES/image-2.dcm.c4gh", AccessionID: "aa-File-bbbbbb-bbbbbb"},
+	}
+	candidates := []models.FileInfo{
+		{InboxPath: "DATASET_ABC/IMAGES/image-3.dcm.c4gh", Status: "uploaded"},
+		{InboxPath: "DATASET_ABC/IMAGES/image-2.dcm.c4gh", Status: "uploaded"},
+		{InboxPath: "DATASET_ABC/PRIVATE/files.csv.c4gh", Status: "uploaded"},
+		{InboxPath: "DATASET_ABC/IMAGES/image-4.dcm.c4gh", Status: "disabled"},
+	}
+
+	added, conflicts := NewFiles(candidates, members)
+	if len(added) != 1 || added[0].InboxPath != "DATASET_ABC/IMAGES/image-3.dcm.c4gh" {
+		t.Errorf("expected only image-3 to be added, got %v", added)
+	}
+	if len(conflicts) != 1 || conflicts[0].InboxPath != "DATASET_ABC/IMAGES/image-2.dcm.c4gh" {
+		t.Errorf("expected image-2 to conflict, got %v", conflicts)
+	}
+}
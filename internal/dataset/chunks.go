@@ -0,0 +1,179 @@
+package dataset
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/NBISweden/submitter/internal/client"
+	"github.com/NBISweden/submitter/internal/report"
+	"github.com/cenkalti/backoff/v4"
+)
+
+// Chunk statuses in the chunk state file
+const (
+	ChunkPending   = "pending"
+	ChunkSucceeded = "succeeded"
+	ChunkFailed    = "failed"
+)
+
+// ChunkOptions control how the accession ids are sent to dataset/create
+type ChunkOptions struct {
+	// Size is the number of accession ids per request
+	Size int
+	// Retries is how many times a failing chunk is resent before it is marked as failed
+	Retries int
+	// StatePath is where the outcome of every chunk is recorded, nothing is recorded when empty
+	StatePath string
+	// Resume only sends the chunks that have not succeeded according to the state file
+	Resume bool
+}
+
+// ChunkState records which chunks of a dataset creation have been accepted by the SDA API
+type ChunkState struct {
+	DatasetID string   `json:"dataset_id"`
+	Chunks    []*Chunk `json:"chunks"`
+}
+
+type Chunk struct {
+	Index        int      `json:"index"`
+	AccessionIDs []string `json:"accession_ids"`
+	Status       string   `json:"status"`
+	Attempts     int      `json:"attempts"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// NewChunkState splits the accession ids into chunks of size
+func NewChunkState(datasetID string, accessionIDs []string, size int) *ChunkState {
+	state := &ChunkState{DatasetID: datasetID}
+	for i, ids := range slices.Collect(slices.Chunk(accessionIDs, size)) {
+		state.Chunks = append(state.Chunks, &Chunk{Index: i, AccessionIDs: ids, Status: ChunkPending})
+	}
+	return state
+}
+
+// ReadChunkState reads a chunk state file written by a previous run
+func ReadChunkState(path string) (*ChunkState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &ChunkState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("could not read chunk state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// Write stores the chunk state as JSON at path
+func (s *ChunkState) Write(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0640)
+}
+
+// Failed returns the chunks that did not succeed
+func (s *ChunkState) Failed() []*Chunk {
+	var failed []*Chunk
+	for _, c := range s.Chunks {
+		if c.Status != ChunkSucceeded {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+func (c *Chunk) describe() string {
+	return fmt.Sprintf("chunk %d (%s - %s)", c.Index, c.AccessionIDs[0], c.AccessionIDs[len(c.AccessionIDs)-1])
+}
+
+// loadChunkState returns the state to work on, either new chunks of accessionIDs or, when
+// resuming, the state of the previous run
+func loadChunkState(datasetID string, accessionIDs []string, opts ChunkOptions) (*ChunkState, error) {
+	if !opts.Resume {
+		return NewChunkState(datasetID, accessionIDs, opts.Size), nil
+	}
+
+	if opts.StatePath == "" {
+		return nil, fmt.Errorf("resuming requires a chunk state file")
+	}
+
+	state, err := ReadChunkState(opts.StatePath)
+	if err != nil {
+		return nil, err
+	}
+	if state.DatasetID != datasetID {
+		return nil, fmt.Errorf("chunk state %s belongs to dataset %s, not %s", opts.StatePath, state.DatasetID, datasetID)
+	}
+
+	slog.Info("resuming dataset creation", "chunks", len(state.Chunks), "remaining", len(state.Failed()))
+	return state, nil
+}
+
+// sendChunks sends every chunk that has not succeeded yet, retrying each one up to
+// opts.Retries times. The state file is updated after every chunk so that an interrupted
+// run can be resumed.
+func sendChunks(api client.DatasetClient, state *ChunkState, userID string, opts ChunkOptions, step *report.Step) error {
+	for _, chunk := range state.Failed() {
+		operation := func() error {
+			chunk.Attempts++
+			return sendChunk(api, state.DatasetID, userID, chunk.AccessionIDs)
+		}
+		policy := backoff.WithMaxRetries(backoff.NewExponentialBackOff(), uint64(max(opts.Retries, 0)))
+		notify := func(err error, wait time.Duration) {
+			slog.Warn("dataset chunk failed, retrying", "chunk", chunk.Index, "attempt", chunk.Attempts, "err", err, "wait", wait)
+		}
+
+		if err := backoff.RetryNotify(operation, policy, notify); err != nil {
+			chunk.Status = ChunkFailed
+			chunk.Error = err.Error()
+			step.AddProblem("%s: %v", chunk.describe(), err)
+		} else {
+			chunk.Status = ChunkSucceeded
+			chunk.Error = ""
+		}
+
+		if opts.StatePath != "" {
+			if err := state.Write(opts.StatePath); err != nil {
+				return fmt.Errorf("could not record chunk state: %w", err)
+			}
+		}
+	}
+
+	if failed := state.Failed(); len(failed) > 0 {
+		return fmt.Errorf("%d/%d dataset chunks failed, resend them with --resume", len(failed), len(state.Chunks))
+	}
+
+	return nil
+}
+
+func sendChunk(api client.DatasetClient, datasetID string, userID string, accessionIDs []string) error {
+	err := api.CreateDataset(datasetID, accessionIDs, userID)
+	switch {
+	case err == nil:
+		return nil
+	case client.IsBenign(err):
+		slog.Info("dataset already exists, files added to it", "dataset_id", datasetID, "err", err)
+		return nil
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("request ended unexpectedly")
+	case client.IsRetryable(err):
+		return err
+	default:
+		return backoff.Permanent(err)
+	}
+}
@@ -0,0 +1,141 @@
+package dataset
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/client"
+	"github.com/NBISweden/submitter/internal/config"
+)
+
+func TestCreateDatasetInChunks(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	failing := "aa-File-00004"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload client.DatasetPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if slices.Contains(payload.AccessionIDs, failing) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received = append(received, payload.AccessionIDs...)
+	}))
+	t.Cleanup(server.Close)
+
+	api, err := client.New(&config.Config{ClientApiHost: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for i := range 7 {
+		ids = append(ids, fmt.Sprintf("aa-File-%05d", i))
+	}
+	opts := ChunkOptions{Size: 3, Retries: 1, StatePath: filepath.Join(t.TempDir(), "chunks.json")}
+
+	t.Run("Failing chunk", func(t *testing.T) {
+		err := createDataset(api, "aa-Dataset-abc", "user", ids, opts, nil)
+		if err == nil {
+			t.Fatal("expected error when a chunk fails")
+		}
+
+		state, err := ReadChunkState(opts.StatePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		failed := state.Failed()
+		if len(failed) != 1 || failed[0].Index != 1 || failed[0].Attempts != 2 {
+			t.Errorf("expected chunk 1 to fail after 2 attempts, got %+v", failed)
+		}
+		if len(received) != 4 {
+			t.Errorf("expected 4 accession ids to be sent, got %v", received)
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		failing = ""
+		resume := opts
+		resume.Resume = true
+		if err := createDataset(api, "aa-Dataset-abc", "user", nil, resume, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		slices.Sort(received)
+		if !slices.Equal(received, ids) {
+			t.Errorf("expected every accession id to be sent once, got %v", received)
+		}
+	})
+
+	t.Run("Resume other dataset", func(t *testing.T) {
+		resume := opts
+		resume.Resume = true
+		if err := createDataset(api, "aa-Dataset-other", "user", nil, resume, nil); err == nil {
+			t.Error("expected error when resuming with the state of another dataset")
+		}
+	})
+}
+
+func TestSendChunk(t *testing.T) {
+	status, message := http.StatusBadRequest, ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "%q", message)
+	}))
+	t.Cleanup(server.Close)
+
+	api, err := client.New(&config.Config{ClientApiHost: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, message = http.StatusConflict, "dataset already exists"
+	if err := sendChunk(api, "aa-Dataset-abc", "user", []string{"aa-File-00000"}); err != nil {
+		t.Errorf("expected an existing dataset to count as sent, got %v", err)
+	}
+
+	status, message = http.StatusBadRequest, "accession id aa-File-00000 not found"
+	if err := sendChunk(api, "aa-Dataset-abc", "user", []string{"aa-File-00000"}); err == nil || client.IsRetryable(err) {
+		t.Errorf("expected an unknown accession id to fail the chunk, got %v", err)
+	}
+}
+
+func TestCreateDatasetTwoChunks(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests > 1 {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `"dataset already exists"`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	api, err := client.New(&config.Config{ClientApiHost: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{"aa-File-00000", "aa-File-00001", "aa-File-00002"}
+	opts := ChunkOptions{Size: 2, StatePath: filepath.Join(t.TempDir(), "chunks.json")}
+	if err := createDataset(api, "aa-Dataset-abc", "user", ids, opts, nil); err != nil {
+		t.Fatalf("expected the second chunk to be added to the existing dataset, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 chunks to be sent, got %d", requests)
+	}
+}
@@ -2,26 +2,26 @@ package dataset
 
 import (
 	"bufio"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
 	"os"
-	"slices"
+	"strings"
 
 	"github.com/NBISweden/submitter/cmd"
 	"github.com/NBISweden/submitter/helpers"
 	"github.com/NBISweden/submitter/internal/client"
-	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/history"
 	"github.com/NBISweden/submitter/internal/models"
+	"github.com/NBISweden/submitter/internal/report"
+	"github.com/NBISweden/submitter/internal/source"
 	"github.com/spf13/cobra"
 )
 
 var dryRun bool
 var configPath string
 var dataDirectory string
+var resume bool
 
 var datasetCmd = &cobra.Command{
 	Use:   "dataset [flags]",
@@ -30,8 +30,8 @@ var datasetCmd = &cobra.Command{
 	Args: func(cmd *cobra.Command, args []string) error {
 		return nil
 	},
-	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewConfig(configPath)
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := cmd.LoadConfig(configPath)
 		if err != nil {
 			return err
 		}
@@ -43,32 +43,41 @@ var datasetCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		defer api.Close() //nolint:errcheck
 
-		r, err := api.GetUsersFilesWithPrefix()
+		src, closeSource, err := source.Open(cmd.SourceKind(source.KindAPI), cfg)
 		if err != nil {
 			return err
 		}
+		defer closeSource()
 
-		body, err := io.ReadAll(r.Body)
+		files, err := source.Files(src)
 		if err != nil {
 			return err
 		}
 
-		var files []models.FileInfo
-		if err := json.Unmarshal(body, &files); err != nil {
-			return err
+		opts := ChunkOptions{
+			Size:      cfg.DatasetChunkSize,
+			Retries:   cfg.DatasetChunkRetries,
+			StatePath: helpers.GetChunkStatePath(dataDirectory, datasetFolder),
+			Resume:    resume,
 		}
 
-		if !dryRun {
-			err := createStableIDsFile(datasetFolder, files)
-			if err != nil {
-				return fmt.Errorf("failed to create stable ids file: %w", err)
+		// A resumed run sends the accession ids recorded in the chunk state, the stable ids
+		// file has already been written by the first run
+		var fileIDsList []string
+		if !resume {
+			if !dryRun {
+				err := createStableIDsFile(datasetFolder, files)
+				if err != nil {
+					return fmt.Errorf("failed to create stable ids file: %w", err)
+				}
 			}
-		}
 
-		fileIDsList, err := getFileIDsFromFile(datasetFolder)
-		if err != nil {
-			return err
+			fileIDsList, err = getFileIDsFromFile(datasetFolder)
+			if err != nil {
+				return err
+			}
 		}
 
 		slog.Info("nr of files included in dataset", "nr_files", (len(fileIDsList)))
@@ -77,37 +86,49 @@ var datasetCmd = &cobra.Command{
 			return nil
 		}
 
-		err = createDataset(api, datasetID, userID, fileIDsList)
-		if err != nil {
-			return err
+		// A resumed run is recorded as its own step, sending the accession ids of the chunk state
+		name := "dataset"
+		if resume {
+			name = "resume"
 		}
+		return history.RecordCommand(dataDirectory, "dataset", cfg, func(rep *report.Report) error {
+			rep.CountRetries(api.Retries)
+			step := rep.StartStep(name)
+			if err := createDataset(api, datasetID, userID, fileIDsList, opts, step); err != nil {
+				return err
+			}
 
-		return nil
+			count := len(fileIDsList)
+			if state, err := ReadChunkState(opts.StatePath); err == nil {
+				count = 0
+				for _, chunk := range state.Chunks {
+					count += len(chunk.AccessionIDs)
+				}
+			}
+			step.Finish(count, nil)
+
+			return nil
+		})
 	},
 }
 
 func init() {
 	cmd.AddCommand(datasetCmd)
-	datasetCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Toggles dry-run mode. Dry run will not run any state changing API calls")
-	datasetCmd.Flags().StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
-	datasetCmd.Flags().StringVar(&dataDirectory, "data-directory", "data", "Path to directory to write / read intermediate files for stableIDs and fileIDs")
+	datasetCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Toggles dry-run mode. Dry run will not run any state changing API calls")
+	datasetCmd.PersistentFlags().StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
+	datasetCmd.Flags().BoolVar(&resume, "resume", false, "Only resend the chunks that failed in the previous run, as recorded in the chunk state file in the data directory")
+	datasetCmd.PersistentFlags().StringVar(&dataDirectory, "data-directory", "data", "Path to directory to write / read intermediate files for stableIDs and fileIDs, and holding the history")
 }
 
 var ErrFileAlreadyExists = errors.New("file already exists")
 
-type Payload struct {
-	AccessionIDs []string `json:"accession_ids"`
-	DatasetID    string   `json:"dataset_id"`
-	User         string   `json:"user"`
-}
-
 type UserFiles struct {
 	AccessionID string `json:"accessionID"`
 	InboxPath   string `json:"inboxPath"`
 }
 
-func Run(api *client.Client, datasetFolder string, datasetID string, userID string, fileIDsList []string) error {
-	err := createDataset(api, datasetID, userID, fileIDsList)
+func Run(api client.DatasetClient, datasetFolder string, datasetID string, userID string, fileIDsList []string, opts ChunkOptions, step *report.Step) error {
+	err := createDataset(api, datasetID, userID, fileIDsList, opts, step)
 	if err != nil {
 		return err
 	}
@@ -131,78 +152,23 @@ func getFileIDsFromFile(datasetFolder string) ([]string, error) {
 	return fileIDsList, nil
 }
 
-func createDataset(api *client.Client, datasetID string, userID string, fileIDsList []string) error {
+func createDataset(api client.DatasetClient, datasetID string, userID string, fileIDsList []string, opts ChunkOptions, step *report.Step) error {
 	slog.Info("starting dataset")
 
-	if len(fileIDsList) > 100 {
-		err := sendInChunks(fileIDsList, api, datasetID, userID)
-		if err != nil {
-			return err
-		}
+	state, err := loadChunkState(datasetID, fileIDsList, opts)
+	if err != nil {
+		return err
 	}
+	slog.Info("sending accession ids in chunks", "nr_files", len(fileIDsList), "chunk_size", opts.Size, "chunks", len(state.Chunks))
 
-	if len(fileIDsList) <= 100 {
-		payload := Payload{
-			AccessionIDs: fileIDsList,
-			DatasetID:    datasetID,
-			User:         userID,
-		}
-		jsonData, err := json.Marshal(payload)
-		if err != nil {
-			return err
-		}
-
-		response, err := api.PostDatasetCreate(jsonData)
-		if err != nil {
-			if errors.Is(err, io.ErrUnexpectedEOF) {
-			} else {
-				return err
-			}
-		}
-		if response.StatusCode != http.StatusOK {
-			slog.Warn("got non-ok response", "status_code", response.StatusCode)
-		}
-		defer response.Body.Close() //nolint:errcheck
+	if err := sendChunks(api, state, userID, opts, step); err != nil {
+		return err
 	}
 
 	slog.Info("creation of dataset completed!")
 	return nil
 }
 
-func sendInChunks(fileIDsList []string, api *client.Client, datasetID string, userID string) error {
-	slog.Info("more than 100 entries, sending in chunks of 100")
-	chunks := slices.Chunk(fileIDsList, 100)
-	allChunks := slices.Collect(chunks)
-	var nonOkResponds []http.Response
-	for _, chunk := range allChunks {
-		payload := Payload{
-			AccessionIDs: chunk,
-			DatasetID:    datasetID,
-			User:         userID,
-		}
-		jsonData, err := json.Marshal(payload)
-		if err != nil {
-			return err
-		}
-		response, err := api.PostDatasetCreate(jsonData)
-		if err != nil {
-			if errors.Is(err, io.ErrUnexpectedEOF) {
-				continue
-			}
-			return err
-		}
-		if response.StatusCode != http.StatusOK {
-			nonOkResponds = append(nonOkResponds, *response)
-			slog.Warn("got non-ok response", "status_code", response.StatusCode)
-		}
-		defer response.Body.Close() //nolint:errcheck
-	}
-	if len(nonOkResponds) != 0 {
-		slog.Warn("found non-ok responds from SDA API", "non-oks", len(nonOkResponds))
-	}
-	return nil
-}
-
 func createStableIDsFile(datasetFolder string, files []models.FileInfo) error {
 	filePath := helpers.GetStableIDsPath(dataDirectory, datasetFolder)
 	if _, err := os.Stat(filePath); err == nil {
@@ -211,16 +177,42 @@ func createStableIDsFile(datasetFolder string, files []models.FileInfo) error {
 		return err
 	}
 
-	file, err := os.Create(filePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close() //nolint:errcheck
+	return WriteStableIDsFile(filePath, files)
+}
 
+// WriteStableIDsFile writes one "<stable id> <inbox path>" line per file to filePath,
+// replacing the file if it exists
+func WriteStableIDsFile(filePath string, files []models.FileInfo) error {
+	var b strings.Builder
 	for _, f := range files {
-		fmt.Fprintf(file, "%s %s\n", f.AccessionID, f.InboxPath) //nolint:errcheck
+		fmt.Fprintf(&b, "%s %s\n", f.AccessionID, f.InboxPath)
+	}
+
+	if err := helpers.WriteFileAtomic(filePath, []byte(b.String()), 0640); err != nil {
+		return err
 	}
 
 	slog.Info("created file with stable ids", "filePath", filePath)
 	return nil
 }
+
+// ReadStableIDsFile reads the "<stable id> <inbox path>" lines written by WriteStableIDsFile
+func ReadStableIDsFile(filePath string) ([]models.FileInfo, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	var files []models.FileInfo
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		accessionID, inboxPath, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if accessionID == "" {
+			continue
+		}
+		files = append(files, models.FileInfo{AccessionID: accessionID, InboxPath: inboxPath})
+	}
+
+	return files, scanner.Err()
+}
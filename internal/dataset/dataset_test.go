@@ -0,0 +1,42 @@
+package dataset
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+func TestStableIDsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "DATASET_ABC-stableIDs.txt")
+	files := []models.FileInfo{
+		{AccessionID: "aa-File-aaaaaa-aaaaaa", InboxPath: "user/DATASET_ABC/IMAGES/image 1.dcm.c4gh"},
+		{AccessionID: "aa-File-bbbbbb-bbbbbb", InboxPath: "user/DATASET_ABC/IMAGES/image-2.dcm.c4gh"},
+	}
+
+	if err := os.WriteFile(path, []byte("stale content\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteStableIDsFile(path, files); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadStableIDsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(read, files) {
+		t.Errorf("expected %v, got %v", files, read)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left, got %v", entries)
+	}
+}
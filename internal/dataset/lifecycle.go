@@ -0,0 +1,141 @@
+package dataset
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/NBISweden/submitter/cmd"
+	"github.com/NBISweden/submitter/internal/client"
+	"github.com/NBISweden/submitter/internal/database"
+	"github.com/NBISweden/submitter/internal/history"
+	"github.com/NBISweden/submitter/internal/report"
+	"github.com/spf13/cobra"
+)
+
+// Dataset states as recorded in sda.dataset_event_log
+const (
+	StatusRegistered = "registered"
+	StatusReleased   = "released"
+	StatusDeprecated = "deprecated"
+)
+
+// Lifecycle actions and the state a dataset has to be in for them
+const (
+	ActionRelease   = "release"
+	ActionDeprecate = "deprecate"
+)
+
+var requiredStatus = map[string]string{
+	ActionRelease:   StatusRegistered,
+	ActionDeprecate: StatusReleased,
+}
+
+var releaseCmd = &cobra.Command{
+	Use:   "release [flags]",
+	Short: "Release the dataset",
+	Long:  "Release DATASET_ID, making it available for download. The dataset has to be registered and not yet released",
+	RunE: func(_ *cobra.Command, args []string) error {
+		return runLifecycleCommand(ActionRelease)
+	},
+}
+
+var deprecateCmd = &cobra.Command{
+	Use:   "deprecate [flags]",
+	Short: "Deprecate the dataset",
+	Long:  "Deprecate DATASET_ID. Only released datasets can be deprecated",
+	RunE: func(_ *cobra.Command, args []string) error {
+		return runLifecycleCommand(ActionDeprecate)
+	},
+}
+
+func init() {
+	datasetCmd.AddCommand(releaseCmd, deprecateCmd)
+}
+
+func runLifecycleCommand(action string) error {
+	cfg, err := cmd.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	api, err := client.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer api.Close() //nolint:errcheck
+
+	db, err := database.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if dryRun {
+		status, err := db.GetDatasetStatus(cfg.DatasetID)
+		if err != nil {
+			return err
+		}
+		if err := CheckTransition(action, status); err != nil {
+			return err
+		}
+		slog.Info("dry run enabled, dataset not changed", "dataset_id", cfg.DatasetID, "status", status, "action", action)
+		return nil
+	}
+
+	return history.RecordCommand(dataDirectory, "dataset "+action, cfg, func(rep *report.Report) error {
+		rep.CountRetries(api.Retries)
+		step := rep.StartStep(action)
+		if err := ChangeStatus(api, db, cfg.DatasetID, action); err != nil {
+			return err
+		}
+
+		// The dataset has changed status at this point, a failing count is only logged
+		files, err := db.GetDatasetFiles(cfg.DatasetID)
+		if err != nil {
+			slog.Warn("could not count the files of the dataset", "dataset_id", cfg.DatasetID, "err", err)
+		}
+		step.Finish(len(files), nil)
+
+		return nil
+	})
+}
+
+// CheckTransition returns an error if action can not be applied to a dataset in status
+func CheckTransition(action string, status string) error {
+	required, ok := requiredStatus[action]
+	if !ok {
+		return fmt.Errorf("unknown dataset action %q", action)
+	}
+
+	if status != required {
+		return fmt.Errorf("can not %s dataset with status %q, it has to be %q", action, status, required)
+	}
+
+	return nil
+}
+
+// ChangeStatus checks the current status of the dataset in the database and calls the SDA API
+// to release or deprecate it
+func ChangeStatus(api client.DatasetClient, db database.Repository, datasetID string, action string) error {
+	status, err := db.GetDatasetStatus(datasetID)
+	if err != nil {
+		return err
+	}
+
+	if err := CheckTransition(action, status); err != nil {
+		return err
+	}
+
+	switch action {
+	case ActionRelease:
+		err = api.ReleaseDataset(datasetID)
+	case ActionDeprecate:
+		err = api.DeprecateDataset(datasetID)
+	}
+	if err != nil {
+		return fmt.Errorf("could not %s dataset %s: %w", action, datasetID, err)
+	}
+
+	slog.Info("dataset status changed", "dataset_id", datasetID, "action", action, "previous_status", status)
+	return nil
+}
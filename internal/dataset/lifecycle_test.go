@@ -0,0 +1,81 @@
+package dataset
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/database"
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		action  string
+		status  string
+		wantErr bool
+	}{
+		{ActionRelease, StatusRegistered, false},
+		{ActionRelease, StatusReleased, true},
+		{ActionRelease, StatusDeprecated, true},
+		{ActionDeprecate, StatusReleased, false},
+		{ActionDeprecate, StatusRegistered, true},
+		{ActionDeprecate, StatusDeprecated, true},
+		{"rotate", StatusRegistered, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action+" "+tt.status, func(t *testing.T) {
+			err := CheckTransition(tt.action, tt.status)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckTransition(%q, %q) = %v, want error: %v", tt.action, tt.status, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type mockDatasetClient struct {
+	calls []string
+	err   error
+}
+
+func (m *mockDatasetClient) CreateDataset(datasetID string, accessionIDs []string, user string) error {
+	m.calls = append(m.calls, "create "+datasetID)
+	return m.err
+}
+
+func (m *mockDatasetClient) ReleaseDataset(datasetID string) error {
+	m.calls = append(m.calls, "release "+datasetID)
+	return m.err
+}
+
+func (m *mockDatasetClient) DeprecateDataset(datasetID string) error {
+	m.calls = append(m.calls, "deprecate "+datasetID)
+	return m.err
+}
+
+func TestChangeStatus(t *testing.T) {
+	db := database.NewMemory()
+	f := db.AddFile("user", models.FileInfo{InboxPath: "DATASET_ABC/a.c4gh", Status: "ready"})
+	if err := db.AddDatasetFiles("aa-Dataset-abc", f.FileID); err != nil {
+		t.Fatal(err)
+	}
+
+	api := &mockDatasetClient{}
+	if err := ChangeStatus(api, db, "aa-Dataset-abc", ActionDeprecate); err == nil {
+		t.Error("expected an error when deprecating a registered dataset")
+	}
+	if err := ChangeStatus(api, db, "aa-Dataset-abc", ActionRelease); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.calls) != 1 || api.calls[0] != "release aa-Dataset-abc" {
+		t.Errorf("expected a single release request, got %v", api.calls)
+	}
+
+	api.err = errors.New("service unavailable")
+	if err := ChangeStatus(api, db, "aa-Dataset-abc", ActionRelease); !errors.Is(err, api.err) {
+		t.Errorf("expected the api error to be wrapped, got %v", err)
+	}
+	if err := ChangeStatus(api, db, "aa-Dataset-missing", ActionRelease); err == nil {
+		t.Error("expected an error for a dataset that does not exist")
+	}
+}
@@ -0,0 +1,386 @@
+// Package fakesda is an in-process stand-in for the SDA API, used to run the job pipeline
+// end-to-end in tests and rehearsals without a real SDA deployment
+package fakesda
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NBISweden/submitter/internal/database"
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+// File statuses, in the order a file moves through them
+const (
+	StatusUploaded  = "uploaded"
+	StatusSubmitted = "submitted"
+	StatusVerified  = "verified"
+	StatusReady     = "ready"
+)
+
+// Dataset statuses
+const (
+	DatasetRegistered = "registered"
+	DatasetReleased   = "released"
+	DatasetDeprecated = "deprecated"
+)
+
+// Options control how the fake API behaves
+type Options struct {
+	// VerifyAfter is how many file listings an ingested file stays submitted before it is
+	// verified, 0 verifies it at once
+	VerifyAfter int
+	// Latency is added to every response
+	Latency time.Duration
+	// DB, when set, gets the files, file events, stable ids and datasets of the fake, so that
+	// the steps that read the database see the same state as the API
+	DB *database.Memory
+	// DatasetConflict makes dataset/create answer 409 when the dataset already exists, after
+	// adding the files to it
+	DatasetConflict bool
+}
+
+// Failure makes the next Times requests matching Method and Path fail with Status and Body,
+// after letting the first After of them through. Path is matched as a prefix of the request
+// path without the leading slash.
+type Failure struct {
+	Method string
+	Path   string
+	Status int
+	Body   string
+	Times  int
+	After  int
+}
+
+// Dataset is a dataset created through dataset/create
+type Dataset struct {
+	ID           string
+	Status       string
+	AccessionIDs []string
+}
+
+type file struct {
+	info    models.FileInfo
+	user    string
+	pending int
+}
+
+// Server is a fake SDA API. The zero value is not usable, create it with NewServer.
+type Server struct {
+	*httptest.Server
+
+	opts     Options
+	mu       sync.Mutex
+	files    []*file
+	datasets map[string]*Dataset
+	failures []*Failure
+	requests map[string]int
+}
+
+// NewServer starts a fake SDA API with no files, close it with Close
+func NewServer(opts Options) *Server {
+	s := &Server{opts: opts, datasets: map[string]*Dataset{}, requests: map[string]int{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{user}/files", s.listFiles)
+	mux.HandleFunc("POST /file/ingest", s.ingest)
+	mux.HandleFunc("POST /file/accession", s.accession)
+	mux.HandleFunc("POST /dataset/create", s.createDataset)
+	mux.HandleFunc("POST /dataset/release/{id}", s.changeDataset(DatasetRegistered, DatasetReleased))
+	mux.HandleFunc("POST /dataset/deprecate/{id}", s.changeDataset(DatasetReleased, DatasetDeprecated))
+
+	s.Server = httptest.NewServer(s.middleware(mux))
+	return s
+}
+
+// AddFile uploads a file for user. The status defaults to uploaded.
+func (s *Server) AddFile(user string, f models.FileInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f.Status == "" {
+		f.Status = StatusUploaded
+	}
+	if f.FileID == "" {
+		f.FileID = fmt.Sprintf("file-%05d", len(s.files)+1)
+	}
+	s.files = append(s.files, &file{info: f, user: user})
+	if s.opts.DB != nil {
+		s.opts.DB.AddFile(user, f)
+	}
+}
+
+// Fail injects a failure for the requests matching it
+func (s *Server) Fail(f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &f)
+}
+
+// Files returns the files of user and their current state
+func (s *Server) Files(user string) []models.FileInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []models.FileInfo
+	for _, f := range s.files {
+		if f.user == user {
+			files = append(files, f.info)
+		}
+	}
+	return files
+}
+
+// Dataset returns the dataset with id, or nil if it has not been created
+func (s *Server) Dataset(id string) *Dataset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.datasets[id]
+	if !ok {
+		return nil
+	}
+	c := *d
+	c.AccessionIDs = slices.Clone(d.AccessionIDs)
+	return &c
+}
+
+// Requests returns the number of requests received per "<method> <route>", e.g.
+// "POST /file/ingest"
+func (s *Server) Requests() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.requests)
+}
+
+// middleware counts requests, adds latency and serves injected failures
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.Latency > 0 {
+			time.Sleep(s.opts.Latency)
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		s.mu.Lock()
+		s.requests[r.Method+" /"+route(path)]++
+		var failure *Failure
+		for _, f := range s.failures {
+			if f.Times > 0 && f.Method == r.Method && strings.HasPrefix(path, f.Path) {
+				if f.After > 0 {
+					f.After--
+					break
+				}
+				f.Times--
+				failure = f
+				break
+			}
+		}
+		s.mu.Unlock()
+
+		if failure != nil {
+			w.WriteHeader(failure.Status)
+			fmt.Fprint(w, failure.Body) //nolint:errcheck
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// route replaces the ids in a request path, so that requests are counted per endpoint
+func route(path string) string {
+	parts := strings.Split(path, "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "users":
+		parts[1] = "{user}"
+	case len(parts) == 3 && parts[0] == "dataset":
+		parts[2] = "{id}"
+	}
+	return strings.Join(parts, "/")
+}
+
+func (s *Server) listFiles(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	prefix := strings.TrimPrefix(r.URL.Query().Get("path_prefix"), "/")
+
+	s.mu.Lock()
+	files := []models.FileInfo{}
+	for _, f := range s.files {
+		if f.user != user || !strings.HasPrefix(strings.TrimPrefix(f.info.InboxPath, "/"), prefix) {
+			continue
+		}
+		if f.info.Status == StatusSubmitted {
+			if f.pending <= 0 {
+				f.info.Status = StatusVerified
+				s.sync(func(db *database.Memory) error { return db.LogFileEvent(f.info.FileID, StatusVerified) })
+			} else {
+				f.pending--
+			}
+		}
+		files = append(files, f.info)
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, files)
+}
+
+func (s *Server) ingest(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FilePath string `json:"filepath"`
+		User     string `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "json decoding : " + err.Error(), "status": http.StatusBadRequest})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.find(req.User, req.FilePath)
+	switch {
+	case f == nil:
+		writeJSON(w, http.StatusNotFound, "file not found")
+	case f.info.Status != StatusUploaded:
+		writeJSON(w, http.StatusBadRequest, "file is already ingested")
+	default:
+		f.info.Status = StatusSubmitted
+		f.pending = s.opts.VerifyAfter
+		s.sync(func(db *database.Memory) error { return db.LogFileEvent(f.info.FileID, StatusSubmitted) })
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) accession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccessionID string `json:"accession_id"`
+		FilePath    string `json:"filepath"`
+		User        string `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "json decoding : " + err.Error(), "status": http.StatusBadRequest})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.find(req.User, req.FilePath)
+	switch {
+	case f == nil:
+		writeJSON(w, http.StatusNotFound, "file not found")
+	case s.byAccession(req.AccessionID) != nil:
+		writeJSON(w, http.StatusConflict, "accession ID already in use")
+	case f.info.Status != StatusVerified:
+		writeJSON(w, http.StatusBadRequest, fmt.Sprintf("file is %s, not verified", f.info.Status))
+	default:
+		f.info.AccessionID = req.AccessionID
+		f.info.Status = StatusReady
+		s.sync(func(db *database.Memory) error {
+			if err := db.SetStableID(f.info.FileID, req.AccessionID); err != nil {
+				return err
+			}
+			return db.LogFileEvent(f.info.FileID, StatusReady)
+		})
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) createDataset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccessionIDs []string `json:"accession_ids"`
+		DatasetID    string   `json:"dataset_id"`
+		User         string   `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "json decoding : " + err.Error(), "status": http.StatusBadRequest})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range req.AccessionIDs {
+		if f := s.byAccession(id); f == nil || f.user != req.User {
+			writeJSON(w, http.StatusBadRequest, fmt.Sprintf("accession id %s not found", id))
+			return
+		}
+	}
+
+	d, ok := s.datasets[req.DatasetID]
+	if !ok {
+		d = &Dataset{ID: req.DatasetID, Status: DatasetRegistered}
+		s.datasets[req.DatasetID] = d
+	}
+	var fileIDs []string
+	for _, id := range req.AccessionIDs {
+		if !slices.Contains(d.AccessionIDs, id) {
+			d.AccessionIDs = append(d.AccessionIDs, id)
+		}
+		fileIDs = append(fileIDs, s.byAccession(id).info.FileID)
+	}
+	s.sync(func(db *database.Memory) error { return db.AddDatasetFiles(req.DatasetID, fileIDs...) })
+	if ok && s.opts.DatasetConflict {
+		writeJSON(w, http.StatusConflict, "dataset already exists")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) changeDataset(from string, to string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		d, ok := s.datasets[r.PathValue("id")]
+		switch {
+		case !ok:
+			writeJSON(w, http.StatusNotFound, "dataset not found")
+		case d.Status != from:
+			writeJSON(w, http.StatusBadRequest, fmt.Sprintf("dataset is %s, not %s", d.Status, from))
+		default:
+			d.Status = to
+			s.sync(func(db *database.Memory) error { return db.LogDatasetEvent(d.ID, to) })
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+// sync applies a change of the fake to Options.DB, if it is set. The repository has every file
+// of the fake, so a change failing means the fake is broken and is only logged.
+func (s *Server) sync(change func(db *database.Memory) error) {
+	if s.opts.DB == nil {
+		return
+	}
+	if err := change(s.opts.DB); err != nil {
+		slog.Error("could not update the repository of the fake sda api", "err", err)
+	}
+}
+
+func (s *Server) find(user string, inboxPath string) *file {
+	for _, f := range s.files {
+		if f.user == user && f.info.InboxPath == inboxPath {
+			return f
+		}
+	}
+	return nil
+}
+
+func (s *Server) byAccession(accessionID string) *file {
+	for _, f := range s.files {
+		if f.info.AccessionID == accessionID {
+			return f
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
@@ -0,0 +1,152 @@
+package fakesda
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/database"
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+// request sends a request with a JSON body to the fake and returns the status and the body of
+// the response
+func request(t *testing.T, s *Server, method string, path string, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, s.URL+"/"+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, strings.TrimSpace(string(data))
+}
+
+func ingest(t *testing.T, s *Server, path string) (int, string) {
+	t.Helper()
+	return request(t, s, http.MethodPost, "file/ingest", fmt.Sprintf(`{"filepath": %q, "user": "testuser"}`, path))
+}
+
+func setAccession(t *testing.T, s *Server, accessionID string, path string) (int, string) {
+	t.Helper()
+	return request(t, s, http.MethodPost, "file/accession", fmt.Sprintf(`{"accession_id": %q, "filepath": %q, "user": "testuser"}`, accessionID, path))
+}
+
+func status(s *Server, path string) models.FileInfo {
+	for _, f := range s.Files("testuser") {
+		if f.InboxPath == path {
+			return f
+		}
+	}
+	return models.FileInfo{}
+}
+
+func TestTransitions(t *testing.T) {
+	db := database.NewMemory()
+	s := NewServer(Options{VerifyAfter: 1, DB: db})
+	t.Cleanup(s.Close)
+	s.AddFile("testuser", models.FileInfo{InboxPath: "DATASET_ABC/a.c4gh", Size: 1024})
+	s.AddFile("testuser", models.FileInfo{InboxPath: "OTHER/b.c4gh"})
+
+	if code, body := ingest(t, s, "DATASET_ABC/missing.c4gh"); code != http.StatusNotFound || body != `"file not found"` {
+		t.Errorf("expected a missing file to be reported, got %d %s", code, body)
+	}
+	if code, _ := ingest(t, s, "DATASET_ABC/a.c4gh"); code != http.StatusOK {
+		t.Fatalf("expected the file to be ingested, got %d", code)
+	}
+	if code, body := ingest(t, s, "DATASET_ABC/a.c4gh"); code != http.StatusBadRequest || body != `"file is already ingested"` {
+		t.Errorf("expected a second ingest to be rejected, got %d %s", code, body)
+	}
+	if code, _ := setAccession(t, s, "aa-File-aaaaaa-aaaaaa", "DATASET_ABC/a.c4gh"); code != http.StatusBadRequest {
+		t.Errorf("expected accession of a submitted file to be rejected, got %d", code)
+	}
+
+	for i, expected := range []string{StatusSubmitted, StatusVerified} {
+		if _, body := request(t, s, http.MethodGet, "users/testuser/files?path_prefix=DATASET_ABC", ""); strings.Contains(body, "OTHER") {
+			t.Errorf("expected only the files under the path prefix, got %s", body)
+		}
+		if f := status(s, "DATASET_ABC/a.c4gh"); f.Status != expected {
+			t.Errorf("expected the file to be %s after listing %d times, got %s", expected, i+1, f.Status)
+		}
+	}
+
+	if code, _ := setAccession(t, s, "aa-File-aaaaaa-aaaaaa", "DATASET_ABC/a.c4gh"); code != http.StatusOK {
+		t.Fatalf("expected the verified file to get its accession id, got %d", code)
+	}
+	if f := status(s, "DATASET_ABC/a.c4gh"); f.Status != StatusReady || f.AccessionID != "aa-File-aaaaaa-aaaaaa" {
+		t.Errorf("expected the file to be ready with its accession id, got %+v", f)
+	}
+	if code, body := setAccession(t, s, "aa-File-aaaaaa-aaaaaa", "OTHER/b.c4gh"); code != http.StatusConflict || body != `"accession ID already in use"` {
+		t.Errorf("expected a duplicate accession id to be rejected, got %d %s", code, body)
+	}
+
+	create := `{"accession_ids": ["aa-File-aaaaaa-aaaaaa"], "dataset_id": "aa-Dataset-abc", "user": "testuser"}`
+	if code, _ := request(t, s, http.MethodPost, "dataset/create", create); code != http.StatusOK {
+		t.Fatalf("expected the dataset to be created, got %d", code)
+	}
+	if code, _ := request(t, s, http.MethodPost, "dataset/deprecate/aa-Dataset-abc", ""); code != http.StatusBadRequest {
+		t.Errorf("expected a registered dataset not to be deprecated, got %d", code)
+	}
+	if code, _ := request(t, s, http.MethodPost, "dataset/release/aa-Dataset-abc", ""); code != http.StatusOK {
+		t.Errorf("expected the dataset to be released, got %d", code)
+	}
+	if d := s.Dataset("aa-Dataset-abc"); d == nil || d.Status != DatasetReleased || len(d.AccessionIDs) != 1 {
+		t.Errorf("expected a released dataset with 1 file, got %+v", d)
+	}
+
+	t.Run("DB", func(t *testing.T) {
+		files, err := db.GetDatasetFiles("aa-Dataset-abc")
+		if err != nil || len(files) != 1 || files[0].AccessionID != "aa-File-aaaaaa-aaaaaa" || files[0].Size != 1024 {
+			t.Errorf("expected the dataset file with its stable id in the repository, got %+v, %v", files, err)
+		}
+		if status, err := db.GetDatasetStatus("aa-Dataset-abc"); err != nil || status != DatasetReleased {
+			t.Errorf("expected the dataset to be released in the repository, got %q, %v", status, err)
+		}
+		for f, err := range db.UserFiles("testuser", "OTHER", nil, true) {
+			if err != nil || f.Status != StatusUploaded {
+				t.Errorf("expected b.c4gh to still be uploaded in the repository, got %+v, %v", f, err)
+			}
+		}
+	})
+}
+
+func TestFailures(t *testing.T) {
+	s := NewServer(Options{})
+	t.Cleanup(s.Close)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		s.AddFile("testuser", models.FileInfo{InboxPath: "DATASET_ABC/" + name + ".c4gh"})
+	}
+	s.Fail(Failure{Method: http.MethodPost, Path: "file/ingest", Status: http.StatusServiceUnavailable, Body: `"unavailable"`, Times: 2, After: 1})
+
+	var codes []int
+	for _, name := range []string{"a", "b", "c", "d"} {
+		code, _ := ingest(t, s, "DATASET_ABC/"+name+".c4gh")
+		codes = append(codes, code)
+	}
+	expected := []int{http.StatusOK, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK}
+	for i := range expected {
+		if codes[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, codes)
+		}
+	}
+
+	if f := status(s, "DATASET_ABC/b.c4gh"); f.Status != StatusUploaded {
+		t.Errorf("expected a failed request to leave the file uploaded, got %s", f.Status)
+	}
+	if code, _ := request(t, s, http.MethodGet, "users/testuser/files", ""); code != http.StatusOK {
+		t.Errorf("expected other endpoints not to fail, got %d", code)
+	}
+	if requests := s.Requests(); requests["POST /file/ingest"] != 4 || requests["GET /users/{user}/files"] != 1 {
+		t.Errorf("expected the failed requests to be counted, got %v", requests)
+	}
+}
@@ -0,0 +1,133 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NBISweden/submitter/cmd"
+	"github.com/NBISweden/submitter/helpers"
+	"github.com/NBISweden/submitter/internal/report"
+	"github.com/spf13/cobra"
+)
+
+var dataDirectory string
+var filter Filter
+var since string
+
+var historyCmd = &cobra.Command{
+	Use:   "history [flags]",
+	Short: "List past runs",
+	Long:  "List the runs recorded in the history of the data directory, most recent first. Use 'history show <id>' to inspect a single run",
+	RunE: func(_ *cobra.Command, args []string) error {
+		f := filter
+		if f.Status != "" && !slices.Contains([]string{report.StatusSucceeded, report.StatusFailed}, f.Status) {
+			return fmt.Errorf("invalid --status %q, use %s or %s", f.Status, report.StatusSucceeded, report.StatusFailed)
+		}
+		if since != "" {
+			t, err := parseSince(since, time.Now())
+			if err != nil {
+				return err
+			}
+			f.Since = t
+		}
+
+		store, err := Open(helpers.GetHistoryPath(dataDirectory))
+		if err != nil {
+			return err
+		}
+		defer store.Close() //nolint:errcheck
+
+		runs, err := store.List(f)
+		if err != nil {
+			return err
+		}
+		for _, run := range runs {
+			fmt.Println(run)
+		}
+
+		return nil
+	},
+}
+
+var showCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show a past run",
+	Long:  "Print everything recorded about the run with <id> as JSON",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		id, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid run id %q", args[0])
+		}
+
+		store, err := Open(helpers.GetHistoryPath(dataDirectory))
+		if err != nil {
+			return err
+		}
+		defer store.Close() //nolint:errcheck
+
+		run, err := store.Get(id)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(run, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+
+		return nil
+	},
+}
+
+func init() {
+	cmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(showCmd)
+	historyCmd.PersistentFlags().StringVar(&dataDirectory, "data-directory", "data", "Path to the data directory holding the history")
+	historyCmd.Flags().StringVar(&filter.Command, "command", "", "Only list runs of this command, e.g. job, simulate or \"dataset release\"")
+	historyCmd.Flags().StringVar(&filter.DatasetID, "dataset", "", "Only list runs for this dataset id")
+	historyCmd.Flags().StringVar(&filter.Status, "status", "", fmt.Sprintf("Only list runs with this outcome, %s or %s", report.StatusSucceeded, report.StatusFailed))
+	historyCmd.Flags().StringVar(&since, "since", "", "Only list runs started since a date (2006-01-02) or within a duration (e.g. 72h)")
+	historyCmd.Flags().IntVar(&filter.Limit, "limit", 20, "Maximum number of runs to list, 0 lists all of them")
+}
+
+// String summarises the run on one line, e.g.
+// "12	2025-01-02T10:00:00Z	job	aa-Dataset-abc	succeeded	1m30s	ingest:10 accession:10"
+func (r *Run) String() string {
+	steps := make([]string, 0, len(r.Steps))
+	for _, s := range r.Steps {
+		steps = append(steps, fmt.Sprintf("%s:%d", s.Name, s.Count))
+	}
+	outcome := r.Status
+	if r.FailedStep != "" {
+		outcome = fmt.Sprintf("%s (%s)", r.Status, r.FailedStep)
+	}
+
+	return strings.Join([]string{
+		strconv.FormatUint(r.ID, 10),
+		r.StartedAt.Format(time.RFC3339),
+		r.Command,
+		r.DatasetID,
+		outcome,
+		r.Duration.Round(time.Second).String(),
+		strings.Join(steps, " "),
+	}, "\t")
+}
+
+// parseSince reads --since as a date or as a duration before now
+func parseSince(value string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid --since %q, use a date like 2006-01-02 or a duration like 72h", value)
+}
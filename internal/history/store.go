@@ -0,0 +1,228 @@
+// Package history records the runs of the submitter in a bbolt file in the data directory, so
+// that past submissions can be looked up without going through the pod logs
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/NBISweden/submitter/helpers"
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/report"
+	bolt "go.etcd.io/bbolt"
+)
+
+var runsBucket = []byte("runs")
+
+// ErrNotFound is returned by Get for an id that has not been recorded
+var ErrNotFound = errors.New("run not found")
+
+// Run is a recorded run of a command
+type Run struct {
+	ID                uint64        `json:"id"`
+	Command           string        `json:"command"`
+	ConfigFingerprint string        `json:"config_fingerprint"`
+	Profile           string        `json:"profile,omitempty"`
+	Version           string        `json:"version,omitempty"`
+	DatasetFolder     string        `json:"dataset_folder"`
+	DatasetID         string        `json:"dataset_id"`
+	UserID            string        `json:"user_id"`
+	ExpectedFiles     int           `json:"expected_files"`
+	Status            string        `json:"status"`
+	FailedStep        string        `json:"failed_step,omitempty"`
+	Error             string        `json:"error,omitempty"`
+	StartedAt         time.Time     `json:"started_at"`
+	FinishedAt        time.Time     `json:"finished_at"`
+	Duration          time.Duration `json:"duration"`
+	Retries           int           `json:"retries"`
+	Steps             []Step        `json:"steps"`
+	Reports           []string      `json:"reports,omitempty"`
+}
+
+// Step is the outcome of a single step of a run
+type Step struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Count    int    `json:"count"`
+	Retries  int    `json:"retries,omitempty"`
+	Problems int    `json:"problems,omitempty"`
+}
+
+// NewRun summarises the finished report of command, run with cfg. reports are the paths of the
+// files the run wrote its reports to.
+func NewRun(command string, cfg *config.Config, rep *report.Report, reports ...string) *Run {
+	run := &Run{
+		Command:           command,
+		ConfigFingerprint: cfg.Fingerprint(),
+		Profile:           cfg.Profile,
+		Version:           cfg.Version,
+		DatasetFolder:     rep.DatasetFolder,
+		DatasetID:         rep.DatasetID,
+		UserID:            rep.UserID,
+		ExpectedFiles:     rep.ExpectedFiles,
+		Status:            rep.Status,
+		StartedAt:         rep.StartedAt,
+		FinishedAt:        rep.FinishedAt,
+		Duration:          rep.FinishedAt.Sub(rep.StartedAt),
+		Retries:           rep.Retries,
+		Reports:           reports,
+	}
+	if rep.Failure != nil {
+		run.FailedStep = rep.Failure.Step
+		run.Error = rep.Failure.Error
+	}
+	for _, s := range rep.Steps {
+		run.Steps = append(run.Steps, Step{Name: s.Name, Status: s.Status, Count: s.Count, Retries: s.Retries, Problems: len(s.Problems)})
+	}
+
+	return run
+}
+
+// Filter selects runs in List, empty fields match every run
+type Filter struct {
+	Command   string
+	DatasetID string
+	Status    string
+	Since     time.Time
+	// Limit is the maximum number of runs returned, 0 returns all of them
+	Limit int
+}
+
+func (f Filter) match(r *Run) bool {
+	return (f.Command == "" || r.Command == f.Command) &&
+		(f.DatasetID == "" || r.DatasetID == f.DatasetID) &&
+		(f.Status == "" || r.Status == f.Status) &&
+		(f.Since.IsZero() || !r.StartedAt.Before(f.Since))
+}
+
+// Store is the history file. Only one process can have it open at a time.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the history file at path, creating it if it does not exist. It waits at most a few
+// seconds for another process to close the file.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0640, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open history %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(runsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close() //nolint:errcheck
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add records run and sets its id
+func (s *Store) Add(run *Run) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		run.ID = id
+
+		data, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return b.Put(key(id), data)
+	})
+}
+
+// Get returns the run with id
+func (s *Store) Get(id uint64) (*Run, error) {
+	var run *Run
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(runsBucket).Get(key(id))
+		if data == nil {
+			return fmt.Errorf("%w: %d", ErrNotFound, id)
+		}
+		run = &Run{}
+		return json.Unmarshal(data, run)
+	})
+
+	return run, err
+}
+
+// List returns the runs matching filter, most recent first
+func (s *Store) List(filter Filter) ([]*Run, error) {
+	runs := []*Run{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(runsBucket).Cursor()
+		for k, data := c.Last(); k != nil; k, data = c.Prev() {
+			run := &Run{}
+			if err := json.Unmarshal(data, run); err != nil {
+				return fmt.Errorf("could not read run %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			if !filter.match(run) {
+				continue
+			}
+			runs = append(runs, run)
+			if filter.Limit > 0 && len(runs) == filter.Limit {
+				break
+			}
+		}
+		return nil
+	})
+
+	return runs, err
+}
+
+// Record adds run to the history in dataDirectory. A run that can not be recorded is logged
+// rather than failing the command that has already finished.
+func Record(dataDirectory string, run *Run) {
+	path := helpers.GetHistoryPath(dataDirectory)
+	store, err := Open(path)
+	if err != nil {
+		slog.Error("could not record run in history", "err", err)
+		return
+	}
+	defer store.Close() //nolint:errcheck
+
+	if err := store.Add(run); err != nil {
+		slog.Error("could not record run in history", "path", path, "err", err)
+		return
+	}
+	slog.Info("recorded run in history", "path", path, "id", run.ID)
+}
+
+// RecordCommand runs the state-changing part of command with a new report, which run adds its
+// steps to, and records the run in the history in dataDirectory whether it succeeds or not
+func RecordCommand(dataDirectory string, command string, cfg *config.Config, run func(rep *report.Report) error) error {
+	rep := report.New(cfg.DatasetFolder, cfg.DatasetID, cfg.UserID, 0)
+	err := run(rep)
+	rep.Finish(err)
+	Record(dataDirectory, NewRun(command, cfg, rep))
+
+	return err
+}
+
+// key encodes id big-endian so that the runs are ordered by id in the bucket
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
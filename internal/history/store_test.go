@@ -0,0 +1,150 @@
+package history
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/report"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+	for i, run := range []*Run{
+		{Command: "job", DatasetID: "aa-Dataset-abc", Status: report.StatusFailed, StartedAt: started},
+		{Command: "job", DatasetID: "aa-Dataset-abc", Status: report.StatusSucceeded, StartedAt: started.Add(time.Hour)},
+		{Command: "job", DatasetID: "aa-Dataset-other", Status: report.StatusSucceeded, StartedAt: started.Add(2 * time.Hour)},
+	} {
+		if err := store.Add(run); err != nil {
+			t.Fatal(err)
+		}
+		if run.ID != uint64(i+1) {
+			t.Errorf("expected id %d, got %d", i+1, run.ID)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close() //nolint:errcheck
+
+	tests := []struct {
+		name   string
+		filter Filter
+		ids    []uint64
+	}{
+		{"All", Filter{}, []uint64{3, 2, 1}},
+		{"Dataset", Filter{DatasetID: "aa-Dataset-abc"}, []uint64{2, 1}},
+		{"Status", Filter{Status: report.StatusSucceeded}, []uint64{3, 2}},
+		{"Since", Filter{Since: started.Add(time.Hour)}, []uint64{3, 2}},
+		{"Limit", Filter{Limit: 1}, []uint64{3}},
+		{"Command", Filter{Command: "release"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs, err := store.List(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []uint64
+			for _, run := range runs {
+				ids = append(ids, run.ID)
+			}
+			if len(ids) != len(tt.ids) {
+				t.Fatalf("expected runs %v, got %v", tt.ids, ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.ids[i] {
+					t.Errorf("expected runs %v, got %v", tt.ids, ids)
+				}
+			}
+		})
+	}
+
+	run, err := store.Get(2)
+	if err != nil || run.Status != report.StatusSucceeded {
+		t.Errorf("expected run 2 to have succeeded, got %+v, %v", run, err)
+	}
+	if _, err := store.Get(4); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestNewRun(t *testing.T) {
+	rep := report.New("DATASET_ABC", "aa-Dataset-abc", "user", 2)
+	step := rep.StartStep("ingest")
+	step.Finish(2, nil)
+	step = rep.StartStep("accession")
+	step.AddProblem("DATASET_ABC/a.c4gh: file not found")
+	rep.Finish(errors.New("accession failed"))
+
+	run := NewRun("job", &config.Config{DatasetID: "aa-Dataset-abc", ClientAccessToken: "secret"}, rep, "data/DATASET_ABC-report.json")
+	if run.Status != report.StatusFailed || run.FailedStep != "accession" || run.Error != "accession failed" {
+		t.Errorf("expected the accession step to have failed, got %+v", run)
+	}
+	if len(run.Steps) != 2 || run.Steps[0].Count != 2 || run.Steps[1].Problems != 1 {
+		t.Errorf("expected the counts per step, got %+v", run.Steps)
+	}
+	if run.ConfigFingerprint == "" || len(run.Reports) != 1 {
+		t.Errorf("expected a config fingerprint and the report path, got %+v", run)
+	}
+}
+
+func TestRecordCommand(t *testing.T) {
+	dataDirectory := t.TempDir()
+	cfg := &config.Config{DatasetFolder: "DATASET_ABC", DatasetID: "aa-Dataset-abc"}
+
+	failure := errors.New("dataset not found")
+	err := RecordCommand(dataDirectory, "dataset release", cfg, func(rep *report.Report) error {
+		rep.StartStep("release")
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the error of the command, got %v", err)
+	}
+
+	store, err := Open(filepath.Join(dataDirectory, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close() //nolint:errcheck
+	runs, err := store.List(Filter{Command: "dataset release"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Status != report.StatusFailed || runs[0].FailedStep != "release" || runs[0].DatasetID != cfg.DatasetID {
+		t.Errorf("expected the failed release to be recorded, got %+v", runs)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2025-01-02", time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), false},
+		{"72h", now.Add(-72 * time.Hour), false},
+		{"last week", time.Time{}, true},
+		{"-1h", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.value, now)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
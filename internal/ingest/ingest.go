@@ -1,23 +1,24 @@
 package ingest
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"iter"
 	"log/slog"
-	"net/http"
 	"strings"
 
 	"github.com/NBISweden/submitter/cmd"
 	"github.com/NBISweden/submitter/internal/client"
-	"github.com/NBISweden/submitter/internal/config"
-	"github.com/NBISweden/submitter/internal/database"
+	"github.com/NBISweden/submitter/internal/history"
 	"github.com/NBISweden/submitter/internal/models"
+	"github.com/NBISweden/submitter/internal/report"
+	"github.com/NBISweden/submitter/internal/source"
 	"github.com/spf13/cobra"
 )
 
 var dryRun bool
 var configPath string
+var dataDirectory string
 
 var ingestCmd = &cobra.Command{
 	Use:   "ingest [flags]",
@@ -26,8 +27,8 @@ var ingestCmd = &cobra.Command{
 	Args: func(cmd *cobra.Command, args []string) error {
 		return nil
 	},
-	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewConfig(configPath)
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := cmd.LoadConfig(configPath)
 		if err != nil {
 			return err
 		}
@@ -35,16 +36,28 @@ var ingestCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		files, err := api.GetUsersFiles()
+		defer api.Close() //nolint:errcheck
+		src, closeSource, err := source.Open(cmd.SourceKind(source.KindAPI), cfg)
 		if err != nil {
 			return err
 		}
-		_, err = ingestFiles(api, cfg.DatasetFolder, cfg.UserID, files)
-		if err != nil {
-			return err
-		}
-
-		return nil
+		defer closeSource()
+
+		return history.RecordCommand(dataDirectory, "ingest", cfg, func(rep *report.Report) error {
+			rep.CountRetries(api.Retries)
+			step := rep.StartStep("ingest")
+			paths, err := uploadedPaths(src.Stream("uploaded"), cfg.DatasetFolder)
+			if err != nil {
+				return err
+			}
+			count, err := ingestFiles(api, cfg.UserID, paths, step)
+			if err != nil {
+				return err
+			}
+			step.Finish(count, nil)
+
+			return nil
+		})
 	},
 }
 
@@ -52,24 +65,31 @@ func init() {
 	cmd.AddCommand(ingestCmd)
 	ingestCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Toggles dry-run mode. Dry run will not run any state changing API calls")
 	ingestCmd.Flags().StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
+	ingestCmd.Flags().StringVar(&dataDirectory, "data-directory", "data", "Path to the data directory holding the history")
 }
 
-func Run(api client.APIClient, db database.PostgresDb, datasetFolder string, userID string, expectedFiles int) (int, error) {
-	files, err := db.GetUserFiles(userID, datasetFolder, true)
+// Run ingests the uploaded files of the dataset folder, after checking that there are
+// expectedFiles of them
+func Run(api client.APIClient, src source.FileSource, datasetFolder string, userID string, expectedFiles int, step *report.Step) (int, error) {
+	paths, err := uploadedPaths(src.Stream("uploaded"), datasetFolder)
 	if err != nil {
 		return 0, err
 	}
 
-	filteredFiles := filterFiles(files, datasetFolder)
-	if expectedFiles != len(filteredFiles) {
-		return 0, fmt.Errorf("expected nr of files does not match files from db, got %d expected %d", len(files), expectedFiles)
+	if expectedFiles != len(paths) {
+		return 0, fmt.Errorf("expected nr of files does not match files from db, got %d expected %d", len(paths), expectedFiles)
 	}
-	return ingestFiles(api, datasetFolder, userID, files)
+	return ingestFiles(api, userID, paths, step)
 }
 
-func filterFiles(files []models.FileInfo, datasetFolder string) []string {
-	var filteredFiles []string
-	for _, f := range files {
+// uploadedPaths reads files one at a time and keeps the paths of the uploaded files in the
+// dataset folder, leaving out the PRIVATE and LANDING PAGE folders
+func uploadedPaths(files iter.Seq2[models.FileInfo, error], datasetFolder string) ([]string, error) {
+	var paths []string
+	for f, err := range files {
+		if err != nil {
+			return nil, err
+		}
 		if f.Status != "uploaded" {
 			continue
 		}
@@ -79,63 +99,55 @@ func filterFiles(files []models.FileInfo, datasetFolder string) []string {
 		if strings.Contains(f.InboxPath, "PRIVATE") || strings.Contains(f.InboxPath, "LANDING PAGE") {
 			continue
 		}
-		filteredFiles = append(filteredFiles, f.InboxPath)
+		paths = append(paths, f.InboxPath)
 	}
-	return filteredFiles
+	return paths, nil
 }
 
-func ingestFiles(api client.APIClient, datasetFolder string, userID string, files []models.FileInfo) (int, error) {
+func ingestFiles(api client.APIClient, userID string, paths []string, step *report.Step) (int, error) {
 	slog.Info("starting ingest")
-	fileList := filterFiles(files, datasetFolder)
 
-	filesCount := len(fileList)
+	filesCount := len(paths)
 	slog.Info("number of files to ingest", "filesCount", filesCount)
 	if dryRun {
 		slog.Info("dry-run enabled. No files will be ingested")
 		return filesCount, nil
 	}
 
-	var resendPayloads []map[string]string
-	var nonOKResponds []int
-	var okResponds []int
-
-	for _, path := range fileList {
-		payload := map[string]string{
-			"filepath": path,
-			"user":     userID,
-		}
-		data, _ := json.Marshal(payload)
-
-		response, err := api.PostFileIngest(data)
-		if err != nil {
-			return filesCount, err
-		}
-
-		if response.StatusCode != http.StatusOK {
-			nonOKResponds = append(nonOKResponds, response.StatusCode)
-			resendPayloads = append(resendPayloads, payload)
-		}
-
-		if response.StatusCode == http.StatusOK {
-			okResponds = append(okResponds, response.StatusCode)
+	ingested := 0
+	failures := make(map[string]int)
+	for _, path := range paths {
+		err := api.IngestFile(path, userID)
+		switch {
+		case err == nil:
+			ingested++
+		case client.IsBenign(err):
+			slog.Info("file already ingested", "filepath", path)
+			ingested++
+		case client.IsFatal(err) || client.IsRetryable(err):
+			return ingested, fmt.Errorf("ingest stopped at %s: %w", path, err)
+		default:
+			failures[failureKind(err)]++
+			step.AddProblem("%s: %v", path, err)
 		}
+	}
 
-		io.Copy(io.Discard, response.Body) //nolint:errcheck
-		response.Body.Close()              //nolint:errcheck
+	for kind, count := range failures {
+		slog.Warn("files could not be ingested", "count", count, "reason", kind)
 	}
 
-	if len(resendPayloads) != 0 {
-		slog.Warn("found non-ok responds from SDA API", "non-oks", len(resendPayloads))
-		countResponds := make(map[int]int)
-		for _, code := range nonOKResponds {
-			countResponds[code]++
-		}
+	slog.Info(fmt.Sprintf("ingested %d/%d successful responses", ingested, filesCount))
+	return ingested, nil
+}
 
-		for code, count := range countResponds {
-			slog.Warn("non-ok responds", "count", count, "code", code)
-		}
+// failureKind names the kind of a failed request for the summary of failures
+func failureKind(err error) string {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && apiErr.Kind != nil {
+		return apiErr.Kind.Error()
 	}
-
-	slog.Info(fmt.Sprintf("ingested %d/%d successful responses", len(okResponds), filesCount))
-	return len(okResponds), nil
+	if errors.As(err, &apiErr) {
+		return apiErr.Status
+	}
+	return err.Error()
 }
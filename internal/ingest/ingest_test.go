@@ -1,31 +1,39 @@
 package ingest
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"iter"
 	"testing"
 
+	"github.com/NBISweden/submitter/internal/client"
 	"github.com/NBISweden/submitter/internal/models"
+	"github.com/NBISweden/submitter/internal/report"
 )
 
 type mockClient struct {
 	FilesToReturn []models.FileInfo
-	Response      *http.Response
+	Errors        map[string]error
 	CallIndex     int
 }
 
-func (m *mockClient) GetUsersFiles() ([]models.FileInfo, error) {
-	return m.FilesToReturn, nil
+func (m *mockClient) IngestFile(filepath string, user string) error {
+	return m.Errors[filepath]
 }
 
-func (m *mockClient) PostFileIngest(data []byte) (*http.Response, error) {
-	return m.Response, nil
+func (m *mockClient) SetAccession(accessionID string, filepath string, user string) error {
+	return m.Errors[filepath]
 }
 
-func (m *mockClient) PostFileAccession(payload []byte) (*http.Response, error) {
-	return m.Response, nil
+// stream lists files the way a file source does
+func stream(files []models.FileInfo) iter.Seq2[models.FileInfo, error] {
+	return func(yield func(models.FileInfo, error) bool) {
+		for _, f := range files {
+			if !yield(f, nil) {
+				return
+			}
+		}
+	}
 }
 
 func setup(userID string, datasetFolder string) *mockClient {
@@ -36,7 +44,6 @@ func setup(userID string, datasetFolder string) *mockClient {
 			{InboxPath: fmt.Sprintf("/%s/PRIVATE/%s/file4.c4gh", userID, datasetFolder), Status: "uploaded"},
 			{InboxPath: fmt.Sprintf("/%s/%s/file5.c4gh", userID, datasetFolder), Status: "error"},
 		},
-		Response: &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString("ok"))},
 	}
 	return mock
 }
@@ -48,11 +55,11 @@ func TestIngest(t *testing.T) {
 	mock := setup(userID, datasetFolder)
 
 	t.Run("Test Ingest", func(t *testing.T) {
-		userFiles, err := mock.GetUsersFiles()
+		paths, err := uploadedPaths(stream(mock.FilesToReturn), datasetFolder)
 		if err != nil {
 			t.Error(err)
 		}
-		files, err := ingestFiles(mock, datasetFolder, userID, userFiles)
+		files, err := ingestFiles(mock, userID, paths, nil)
 		if err != nil {
 			t.Error(err)
 		}
@@ -63,3 +70,37 @@ func TestIngest(t *testing.T) {
 		t.Logf("ingested %d/%d files sucessfully", files, expectedFiles)
 	})
 }
+
+func TestIngestErrors(t *testing.T) {
+	userID := "testuser"
+	datasetFolder := "DATASET_TEST"
+	mock := setup(userID, datasetFolder)
+	file1 := fmt.Sprintf("/%s/%s/file1.c4gh", userID, datasetFolder)
+	file2 := fmt.Sprintf("/%s/%s/file2.c4gh", userID, datasetFolder)
+	paths, err := uploadedPaths(stream(mock.FilesToReturn), datasetFolder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Benign and per file errors", func(t *testing.T) {
+		mock.Errors = map[string]error{
+			file1: &client.APIError{Kind: client.ErrAlreadyIngested},
+			file2: &client.APIError{Kind: client.ErrFileNotFound},
+		}
+		step := &report.Step{}
+		ingested, err := ingestFiles(mock, userID, paths, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ingested != 1 || len(step.Problems) != 1 {
+			t.Errorf("expected 1 ingested file and 1 problem, got %d and %v", ingested, step.Problems)
+		}
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		mock.Errors = map[string]error{file1: &client.APIError{Kind: client.ErrUnauthorized}}
+		if _, err := ingestFiles(mock, userID, paths, nil); !errors.Is(err, client.ErrUnauthorized) {
+			t.Errorf("expected ingest to stop when unauthorized, got %v", err)
+		}
+	})
+}
@@ -1,23 +1,78 @@
 package job
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"time"
 
 	"github.com/NBISweden/submitter/cmd"
+	"github.com/NBISweden/submitter/helpers"
 	"github.com/NBISweden/submitter/internal/accession"
+	"github.com/NBISweden/submitter/internal/alert"
+	"github.com/NBISweden/submitter/internal/artifacts"
+	"github.com/NBISweden/submitter/internal/attachments"
 	"github.com/NBISweden/submitter/internal/client"
 	"github.com/NBISweden/submitter/internal/config"
 	"github.com/NBISweden/submitter/internal/database"
 	"github.com/NBISweden/submitter/internal/dataset"
+	"github.com/NBISweden/submitter/internal/history"
 	"github.com/NBISweden/submitter/internal/ingest"
+	"github.com/NBISweden/submitter/internal/lint"
+	"github.com/NBISweden/submitter/internal/mail"
+	"github.com/NBISweden/submitter/internal/metadata"
+	"github.com/NBISweden/submitter/internal/models"
+	"github.com/NBISweden/submitter/internal/notify"
+	"github.com/NBISweden/submitter/internal/rems"
+	"github.com/NBISweden/submitter/internal/report"
+	"github.com/NBISweden/submitter/internal/source"
 	"github.com/spf13/cobra"
 )
 
 var configPath string
-var expectedFiles int
+var options Options
+
+// Options select the steps of a job and where it keeps its files
+type Options struct {
+	ExpectedFiles int
+	DataDirectory string
+	// Source is the file source to read the user's files from, see source.New
+	Source           string
+	Resume           bool
+	Append           bool
+	Lint             bool
+	ValidateMetadata bool
+	MetadataDir      string
+	Rems             bool
+	Artifacts        bool
+	Release          bool
+	Notify           bool
+	// Command is the name the run is recorded under in the history, job when empty
+	Command string
+	// API and DB are used instead of the SDA API client and the database connection that are
+	// otherwise created from the configuration, e.g. to run the job against a fake backend.
+	// The job does not close DB.
+	API client.JobClient
+	DB  database.Repository
+}
+
+// Validate rejects combinations of steps that would silently do nothing
+func (o Options) Validate() error {
+	if o.Artifacts && !o.Notify {
+		return fmt.Errorf("--artifacts requires --notify, the artifacts are generated for the job_finished mails")
+	}
+	return nil
+}
+
+// datasetPollInterval is how often the database is checked while verifying the dataset
+const datasetPollInterval = time.Minute
+
+// stableIDPollInterval is how often the database is checked for the accession ids before the
+// dataset is requested
+const stableIDPollInterval = 10 * time.Second
 
 var jobCmd = &cobra.Command{
 	Use:   "job <expectedFiles>",
@@ -34,18 +89,23 @@ var jobCmd = &cobra.Command{
 			return fmt.Errorf("job can only handle one argument")
 		}
 
-		expectedFiles, err = strconv.Atoi(args[0])
+		options.ExpectedFiles, err = strconv.Atoi(args[0])
 		if err != nil {
 			return fmt.Errorf("could not interpert expected number of files %w", err)
 		}
-		return nil
+		return options.Validate()
 	},
 
-	RunE: func(cmd *cobra.Command, args []string) error {
-		err := runJob(expectedFiles)
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := cmd.LoadConfig(configPath)
 		if err != nil {
 			return err
 		}
+
+		options.Source = cmd.SourceKind(source.KindDB)
+		if _, err := Run(cfg, options); err != nil {
+			return err
+		}
 		return nil
 	},
 }
@@ -53,67 +113,520 @@ var jobCmd = &cobra.Command{
 func init() {
 	cmd.AddCommand(jobCmd)
 	jobCmd.Flags().StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
+	jobCmd.Flags().StringVar(&options.DataDirectory, "data-directory", "data", "Path to directory to write the job report, alert state and mail attachments to")
+	jobCmd.Flags().BoolVar(&options.Resume, "resume", false, "Resume a failed job from the accession ids saved in the data directory, skipping ingestion and accession")
+	jobCmd.Flags().BoolVar(&options.Append, "append", false, "Add newly uploaded files to the existing dataset DATASET_ID, <expectedFiles> is then the number of files to add")
+	jobCmd.Flags().BoolVar(&options.Lint, "lint", false, "Check the dataset folder structure before ingestion and stop the job if it has violations")
+	jobCmd.Flags().BoolVar(&options.ValidateMetadata, "validate-metadata", false, "Validate the BigPicture metadata before ingestion and stop the job if it is invalid")
+	jobCmd.Flags().StringVar(&options.MetadataDir, "metadata-dir", "", "Local directory with the metadata xml files. When empty they are fetched from the inbox with METADATA_DOWNLOAD_COMMAND")
+	jobCmd.Flags().BoolVar(&options.Rems, "rems", false, "Create or update the REMS resource and catalogue item for the dataset after it has been created")
+	jobCmd.Flags().BoolVar(&options.Artifacts, "artifacts", false, "Generate dataset.txt, policy.txt and rems.txt before sending notifications (requires --notify)")
+	jobCmd.Flags().BoolVar(&options.Release, "release", false, "Release the dataset as the final step of the job")
+	jobCmd.Flags().BoolVar(&options.Notify, "notify", false, "Verify the dataset, write the stable ids file and send the job_finished mail notifications as a final step")
 }
 
-func runJob(expectedFiles int) error {
-	cfg, err := config.NewConfig(configPath)
+// Run runs the steps of a job selected by opts, alerts if it fails, writes the job report to
+// the data directory and records the run in its history
+func Run(cfg *config.Config, opts Options) (*report.Report, error) {
+	notifier, err := notify.New(cfg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	pollRate := time.Minute * time.Duration(cfg.PollRate)
+	rep := report.New(cfg.DatasetFolder, cfg.DatasetID, cfg.UserID, opts.ExpectedFiles)
+	err = runJob(cfg, opts, rep, notifier)
+	rep.Finish(err)
+
+	if err != nil {
+		if alertErr := alert.Send(cfg, opts.DataDirectory, notifier, rep); alertErr != nil {
+			slog.Error("could not alert about failed job", "err", alertErr)
+		}
+	}
+
+	reportPath := helpers.GetReportPath(opts.DataDirectory, cfg.DatasetFolder)
+	if writeErr := rep.Write(reportPath); writeErr != nil {
+		slog.Error("could not write job report", "err", writeErr)
+	}
+	command := opts.Command
+	if command == "" {
+		command = "job"
+	}
+	history.Record(opts.DataDirectory, history.NewRun(command, cfg, rep, reportPath))
+
+	return rep, err
+}
+
+func runJob(cfg *config.Config, opts Options, rep *report.Report, notifier *notify.Dispatcher) error {
 	timeout := time.Minute * time.Duration(cfg.Timeout)
 	datasetFolder := cfg.DatasetFolder
 	datasetID := cfg.DatasetID
 	userID := cfg.UserID
 
-	slog.Info("dispatching job", "dataset_folder", datasetFolder, "dataset_id", datasetID, "userID", userID, "expected_files", expectedFiles)
+	slog.Info("dispatching job", "dataset_folder", datasetFolder, "dataset_id", datasetID, "userID", userID, "expected_files", opts.ExpectedFiles)
+	sendEvent(notifier, rep, notify.NewEvent(notify.EventJobStarted, rep, fmt.Sprintf("job started for %s, expecting %d files", datasetFolder, opts.ExpectedFiles)))
 
-	api, err := client.New(cfg)
+	api := opts.API
+	if api == nil {
+		c, err := client.New(cfg)
+		if err != nil {
+			return err
+		}
+		defer c.Close() //nolint:errcheck
+		api = c
+	}
+	rep.CountRetries(api.Retries)
+
+	// The database is only needed to list files and to follow the dataset after it has been
+	// requested, a job that reads the files from the api can run without it
+	db := opts.DB
+	if db == nil && (opts.Source == source.KindDB || opts.Append || opts.Notify || opts.Release) {
+		postgres, err := database.New(cfg)
+		if err != nil {
+			return err
+		}
+		defer postgres.Close()
+		db = postgres
+	}
+
+	src, err := source.New(opts.Source, cfg, api, db)
 	if err != nil {
 		return err
 	}
 
-	db, err := database.New(cfg)
+	// The REMS configuration is checked up front so that the job does not fail after the dataset
+	// has been created because of a missing setting
+	var remsClient *rems.Client
+	if opts.Rems {
+		remsClient, err = rems.New(cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	var members []models.FileInfo
+	if opts.Append {
+		step := rep.StartStep("membership")
+		members, err = db.GetDatasetFiles(datasetID)
+		if err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			return fmt.Errorf("dataset %s has no files, run the job without --append to create it", datasetID)
+		}
+
+		rep.Membership = &report.Membership{Before: len(members)}
+
+		// A resumed job may already have added some of the files, they are checked when
+		// verifying the dataset instead
+		if !opts.Resume {
+			candidates, err := source.Files(src)
+			if err != nil {
+				return err
+			}
+
+			added, conflicts := dataset.NewFiles(candidates, members)
+			for _, f := range conflicts {
+				step.AddProblem("%s: already in the dataset, replacing files is not supported", f.InboxPath)
+			}
+			if len(conflicts) > 0 {
+				return fmt.Errorf("%d new files have the same path as files in dataset %s", len(conflicts), datasetID)
+			}
+			if len(added) != opts.ExpectedFiles {
+				return fmt.Errorf("found %d files to add to dataset %s, expected %d", len(added), datasetID, opts.ExpectedFiles)
+			}
+
+			for _, f := range added {
+				rep.Membership.Added = append(rep.Membership.Added, f.InboxPath)
+			}
+			slog.Info("appending files to existing dataset", "dataset_id", datasetID, "files_in_dataset", len(members), "files_to_add", len(added))
+		}
+		completeStep(notifier, rep, step, len(rep.Membership.Added))
+	}
+
+	if opts.Lint && !opts.Resume {
+		step := rep.StartStep("lint")
+		files, err := source.Files(src)
+		if err != nil {
+			return err
+		}
+
+		violations := lint.NewRules(cfg).Check(files, datasetFolder)
+		for _, v := range violations {
+			step.AddProblem("%s", v)
+		}
+		if err := lint.Err(violations); err != nil {
+			return err
+		}
+		completeStep(notifier, rep, step, len(files))
+	}
+
+	if opts.ValidateMetadata && !opts.Resume {
+		step := rep.StartStep("metadata")
+		inbox, err := source.Files(src)
+		if err != nil {
+			return err
+		}
+
+		result, err := metadata.Check(cfg, opts.MetadataDir, inbox)
+		if err != nil {
+			return err
+		}
+		for _, problem := range result.Problems {
+			step.AddProblem("%s", problem)
+		}
+		if err := result.Err(); err != nil {
+			return err
+		}
+		completeStep(notifier, rep, step, len(result.Files))
+	}
+
+	var accessioned []models.FileInfo
+	if opts.Resume {
+		step := rep.StartStep("resume")
+		accessioned, err = loadAccessionIDs(opts, datasetFolder)
+		if err != nil {
+			return err
+		}
+		completeStep(notifier, rep, step, len(accessioned))
+
+		// A job that stopped partway through accession saved the ids it got so far, the
+		// remaining verified files get theirs now
+		if len(accessioned) < opts.ExpectedFiles {
+			accessioned, err = accessionFiles(cfg, opts, api, db, src, accessioned, rep, notifier)
+			if err != nil {
+				return err
+			}
+		}
+		if opts.Append {
+			for _, f := range accessioned {
+				rep.Membership.Added = append(rep.Membership.Added, f.InboxPath)
+			}
+		}
+	} else {
+		accessioned, err = ingestAndAccession(cfg, opts, api, db, src, rep, notifier)
+		if err != nil {
+			return err
+		}
+	}
+
+	accessionIDs := make([]string, 0, len(accessioned))
+	for _, f := range accessioned {
+		accessionIDs = append(accessionIDs, f.AccessionID)
+	}
+
+	step := rep.StartStep("dataset")
+	chunkOptions := dataset.ChunkOptions{
+		Size:      cfg.DatasetChunkSize,
+		Retries:   cfg.DatasetChunkRetries,
+		StatePath: helpers.GetChunkStatePath(opts.DataDirectory, datasetFolder),
+	}
+	if opts.Resume {
+		// Only the chunks that failed are resent if the previous run got as far as the dataset step
+		if state, err := dataset.ReadChunkState(chunkOptions.StatePath); err == nil && state.DatasetID == datasetID {
+			chunkOptions.Resume = true
+		}
+	}
+	err = dataset.Run(api, datasetFolder, datasetID, userID, accessionIDs, chunkOptions, step)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	completeStep(notifier, rep, step, len(accessionIDs))
+
+	if opts.Rems {
+		step = rep.StartStep("rems")
+		result, err := remsClient.Register(datasetID)
+		if err != nil {
+			return err
+		}
+		rep.Rems = &report.Rems{
+			ResourceID:           result.ResourceID,
+			CatalogueItemID:      result.CatalogueItemID,
+			CreatedResource:      result.CreatedResource,
+			CreatedCatalogueItem: result.CreatedCatalogueItem,
+			UpdatedCatalogueItem: result.UpdatedCatalogueItem,
+		}
+		completeStep(notifier, rep, step, 1)
+	}
 
-	filesCount, err := ingest.Run(api, *db, datasetFolder, userID, expectedFiles)
+	var files []models.FileInfo
+	if opts.Notify || opts.Append {
+		step = rep.StartStep("verify_dataset")
+		files, err = waitForDataset(db, datasetID, datasetSize(members, accessioned), timeout)
+		if err != nil {
+			return err
+		}
+
+		if err := dataset.WriteStableIDsFile(helpers.GetStableIDsPath(opts.DataDirectory, datasetFolder), files); err != nil {
+			return fmt.Errorf("failed to create stable ids file: %w", err)
+		}
+		if rep.Membership != nil {
+			rep.Membership.After = len(files)
+			slog.Info("files appended to dataset", "dataset_id", datasetID, "before", rep.Membership.Before, "after", rep.Membership.After)
+		}
+		completeStep(notifier, rep, step, len(files))
+	}
+
+	if opts.Notify {
+		if opts.Artifacts {
+			step = rep.StartStep("artifacts")
+			if err := artifacts.Write(cfg, opts.DataDirectory, files); err != nil {
+				return err
+			}
+			completeStep(notifier, rep, step, len(attachments.Names))
+		}
+
+		step = rep.StartStep("notify")
+		sent, err := sendCompletionMails(cfg, opts.DataDirectory, rep, step, files)
+		if err != nil {
+			return err
+		}
+		completeStep(notifier, rep, step, sent)
+	}
+
+	if opts.Release {
+		step = rep.StartStep("release")
+		if err := waitForDatasetStatus(db, datasetID, timeout); err != nil {
+			return err
+		}
+		if err := dataset.ChangeStatus(api, db, datasetID, dataset.ActionRelease); err != nil {
+			return err
+		}
+		completeStep(notifier, rep, step, len(accessionIDs))
+	}
+
+	slog.Info("dataset submission completed!")
+	sendEvent(notifier, rep, notify.NewEvent(notify.EventJobFinished, rep, fmt.Sprintf("dataset submission completed with %d files", len(accessionIDs))))
+	return nil
+}
+
+// ingestAndAccession ingests the files of the dataset folder, waits for them to be verified and
+// assigns accession ids, which are saved to the data directory
+func ingestAndAccession(cfg *config.Config, opts Options, api client.JobClient, db database.Repository, src source.FileSource, rep *report.Report, notifier *notify.Dispatcher) ([]models.FileInfo, error) {
+	pollRate := time.Minute * time.Duration(cfg.PollRate)
+	timeout := time.Minute * time.Duration(cfg.Timeout)
+	stallAfter := time.Minute * time.Duration(cfg.NotifyStallAfter)
+	datasetFolder := cfg.DatasetFolder
+	userID := cfg.UserID
+
+	step := rep.StartStep("ingest")
+	filesCount, err := ingest.Run(api, src, datasetFolder, userID, opts.ExpectedFiles, step)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	completeStep(notifier, rep, step, filesCount)
 
-	if filesCount != expectedFiles {
-		return fmt.Errorf("ingest did not return the expected number of files, got %d expected %d", filesCount, expectedFiles)
+	if filesCount != opts.ExpectedFiles {
+		return nil, fmt.Errorf("ingest did not return the expected number of files, got %d expected %d", filesCount, opts.ExpectedFiles)
 	}
 
-	_, err = api.WaitForAccession(filesCount, pollRate, timeout)
+	step = rep.StartStep("verify")
+	verified, err := api.WaitForAccession(filesCount, pollRate, timeout, stallAfter, func(found int, stalledFor time.Duration) {
+		event := notify.NewEvent(notify.EventWaitingStalled, rep, fmt.Sprintf("no progress for %s while waiting for verification, %d/%d files verified", stalledFor.Round(time.Minute), found, filesCount))
+		event.Step = step.Name
+		sendEvent(notifier, rep, event)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	completeStep(notifier, rep, step, len(verified))
+
+	return accessionFiles(cfg, opts, api, db, src, nil, rep, notifier)
+}
+
+// accessionFiles assigns accession ids to the verified files that are not in saved and saves
+// the ids of saved and the new files to the data directory
+func accessionFiles(cfg *config.Config, opts Options, api client.APIClient, db database.Repository, src source.FileSource, saved []models.FileInfo, rep *report.Report, notifier *notify.Dispatcher) ([]models.FileInfo, error) {
+	datasetFolder := cfg.DatasetFolder
 
-	accessionIDs, err := accession.Run(api, *db, datasetFolder, userID)
+	step := rep.StartStep("accession")
+	accessioned, err := accession.Resume(api, src, datasetFolder, cfg.UserID, saved, step)
+
+	// The accession ids are saved before anything else can fail, including when only some files
+	// got one, so that the ids are not lost and the job can be resumed
+	if saveErr := saveAccessionIDs(opts.DataDirectory, datasetFolder, accessioned); saveErr != nil {
+		slog.Error("could not save accession ids", "err", saveErr)
+		if err == nil {
+			err = saveErr
+		}
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
+	completeStep(notifier, rep, step, len(accessioned)-len(saved))
 
-	nrAccessionIDs := len(accessionIDs)
-	if nrAccessionIDs != expectedFiles {
-		return fmt.Errorf("accession did not return the expected number of files, got %d expected %d", nrAccessionIDs, expectedFiles)
+	nrAccessionIDs := len(accessioned)
+	if nrAccessionIDs != opts.ExpectedFiles {
+		return nil, fmt.Errorf("accession did not return the expected number of files, got %d expected %d", nrAccessionIDs, opts.ExpectedFiles)
 	}
 
 	// We give some time for the SDA backend to process our accession ids. During test-runs it's been fine with 10 minutes
-	waitTime := 10 * time.Minute
-	slog.Info("waiting before sending dataset creation request", "delay", waitTime)
-	time.Sleep(waitTime)
+	if db == nil {
+		slog.Info("waiting before sending dataset creation request", "delay", cfg.AccessionDelay)
+		time.Sleep(cfg.AccessionDelay)
+	} else {
+		waitForStableIDs(db, accessioned, cfg.AccessionDelay)
+	}
 
-	err = dataset.Run(api, datasetFolder, datasetID, userID, accessionIDs)
-	if err != nil {
-		return err
+	return accessioned, nil
+}
+
+// waitForStableIDs polls the database until the backend has recorded the accession ids of files
+// as their stable ids, for at most timeout. The dataset is requested after timeout even if some
+// are missing, as it is when there is no database to check.
+func waitForStableIDs(db database.Repository, files []models.FileInfo, timeout time.Duration) {
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		ids = append(ids, f.AccessionID)
 	}
 
-	slog.Info("dataset submission completed!")
+	deadline := time.Now().Add(timeout)
+	for {
+		recorded, err := db.GetFilesByStableIDs(ids)
+		if err != nil {
+			slog.Warn("could not check the stable ids", "err", err)
+		} else if len(recorded) >= len(ids) {
+			slog.Info("stable ids recorded", "nr_files", len(recorded))
+			return
+		}
+
+		if time.Now().After(deadline) {
+			slog.Warn("not every stable id has been recorded, requesting the dataset anyway", "recorded", len(recorded), "expected", len(ids))
+			return
+		}
+		slog.Info(fmt.Sprintf("%d/%d stable ids recorded - waiting: interval: %s", len(recorded), len(ids), stableIDPollInterval))
+		time.Sleep(min(stableIDPollInterval, time.Until(deadline)))
+	}
+}
+
+// saveAccessionIDs writes the fileIDs and stableIDs files of the dataset folder
+func saveAccessionIDs(dataDirectory string, datasetFolder string, accessioned []models.FileInfo) error {
+	if err := accession.WriteFileIDsFile(helpers.GetFileIDsPath(dataDirectory, datasetFolder), accessioned); err != nil {
+		return fmt.Errorf("failed to save accession ids: %w", err)
+	}
+	if err := dataset.WriteStableIDsFile(helpers.GetStableIDsPath(dataDirectory, datasetFolder), accessioned); err != nil {
+		return fmt.Errorf("failed to save stable ids: %w", err)
+	}
 	return nil
 }
+
+// loadAccessionIDs reads the accession ids saved by a previous run of the job, which are fewer
+// than expected if it stopped partway through accession
+func loadAccessionIDs(opts Options, datasetFolder string) ([]models.FileInfo, error) {
+	path := helpers.GetStableIDsPath(opts.DataDirectory, datasetFolder)
+	accessioned, err := dataset.ReadStableIDsFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not resume job: %w", err)
+	}
+	if len(accessioned) > opts.ExpectedFiles {
+		return nil, fmt.Errorf("could not resume job: %s has %d accession ids, expected at most %d", path, len(accessioned), opts.ExpectedFiles)
+	}
+
+	slog.Info("resuming job with saved accession ids", "path", path, "nr_files", len(accessioned))
+	return accessioned, nil
+}
+
+// datasetSize is the number of files the dataset should have once files have been added to
+// members, files are counted once even if they are already a member
+func datasetSize(members []models.FileInfo, files []models.FileInfo) int {
+	paths := map[string]bool{}
+	for _, f := range slices.Concat(members, files) {
+		paths[f.InboxPath] = true
+	}
+	return len(paths)
+}
+
+// waitForDataset polls the database until the dataset contains the expected number of files
+func waitForDataset(db database.Repository, datasetID string, expected int, timeout time.Duration) ([]models.FileInfo, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		files, err := db.GetDatasetFiles(datasetID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(files) >= expected {
+			slog.Info("dataset verified", "dataset_id", datasetID, "files", len(files))
+			return files, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("dataset %s only contains %d/%d files", datasetID, len(files), expected)
+		}
+		slog.Info(fmt.Sprintf("dataset contains %d/%d files - waiting: interval: %s", len(files), expected, datasetPollInterval))
+		time.Sleep(datasetPollInterval)
+	}
+}
+
+// waitForDatasetStatus polls the database until the dataset has been registered, since the
+// dataset is created asynchronously after the dataset/create request
+func waitForDatasetStatus(db database.Repository, datasetID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := db.GetDatasetStatus(datasetID)
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("dataset %s was not registered in time: %w", datasetID, err)
+		}
+		slog.Info("waiting for dataset to be registered", "dataset_id", datasetID, "interval", datasetPollInterval)
+		time.Sleep(datasetPollInterval)
+	}
+}
+
+// sendCompletionMails mails the recipients subscribed to job_finished and records the result
+// of every mail in the report
+func sendCompletionMails(cfg *config.Config, dataDirectory string, rep *report.Report, step *report.Step, files []models.FileInfo) (int, error) {
+	m, err := mail.New(cfg, dataDirectory)
+	if err != nil {
+		return 0, err
+	}
+
+	data := m.Data()
+	data.FileCount = len(files)
+	for _, f := range files {
+		data.TotalSize += f.Size
+	}
+	data.AccessionFile = filepath.Base(helpers.GetFileIDsPath(dataDirectory, cfg.DatasetFolder))
+	data.JobStartedAt = rep.StartedAt
+	data.JobFinishedAt = time.Now().UTC()
+	m.SetData(data)
+
+	deliveries := m.Deliver(mail.EventJobFinished, false)
+	if len(deliveries) == 0 {
+		slog.Warn("no mail recipients configured for event", "event", mail.EventJobFinished)
+	}
+
+	var sent int
+	for _, delivery := range deliveries {
+		rep.AddNotification("mail", delivery.Name, mail.EventJobFinished, delivery.Err)
+		if delivery.Err != nil {
+			step.AddProblem("%s: %v", delivery.Name, delivery.Err)
+			continue
+		}
+		sent++
+	}
+
+	if sent != len(deliveries) {
+		return sent, fmt.Errorf("%d/%d mail notifications failed", len(deliveries)-sent, len(deliveries))
+	}
+
+	return sent, nil
+}
+
+// completeStep marks the step as succeeded and lets the notifiers know
+func completeStep(notifier *notify.Dispatcher, rep *report.Report, step *report.Step, count int) {
+	step.Finish(count, nil)
+	event := notify.NewEvent(notify.EventStepCompleted, rep, fmt.Sprintf("step %s completed with %d files", step.Name, count))
+	event.Step = step.Name
+	sendEvent(notifier, rep, event)
+}
+
+// sendEvent notifies about progress and records the outcome in the report, a failing
+// notifier is logged but does not fail the job
+func sendEvent(notifier *notify.Dispatcher, rep *report.Report, event notify.Event) {
+	for _, delivery := range notifier.Deliver(context.Background(), event) {
+		rep.AddNotification("notifier", delivery.Name, event.Type, delivery.Err)
+	}
+}
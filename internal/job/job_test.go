@@ -0,0 +1,259 @@
+package job
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NBISweden/submitter/helpers"
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/database"
+	"github.com/NBISweden/submitter/internal/dataset"
+	"github.com/NBISweden/submitter/internal/fakesda"
+	"github.com/NBISweden/submitter/internal/history"
+	"github.com/NBISweden/submitter/internal/models"
+	"github.com/NBISweden/submitter/internal/report"
+	"github.com/NBISweden/submitter/internal/source"
+)
+
+// newFakeSDA starts a fake SDA API with the files of DATASET_ABC, db is kept in sync with it for
+// the steps that read the database
+func newFakeSDA(t *testing.T) (*fakesda.Server, *config.Config, *database.Memory) {
+	t.Helper()
+	return newFakeSDAWith(t, fakesda.Options{VerifyAfter: 2})
+}
+
+// newFakeSDAWith starts a fake SDA API with opts, backed by a new in-memory repository
+func newFakeSDAWith(t *testing.T, opts fakesda.Options) (*fakesda.Server, *config.Config, *database.Memory) {
+	t.Helper()
+	db := database.NewMemory()
+	opts.DB = db
+	sda := fakesda.NewServer(opts)
+	t.Cleanup(sda.Close)
+
+	for _, path := range []string{
+		"DATASET_ABC/IMAGES/a.c4gh",
+		"DATASET_ABC/IMAGES/b.c4gh",
+		"DATASET_ABC/IMAGES/c.c4gh",
+		"DATASET_ABC/PRIVATE/d.c4gh",
+	} {
+		sda.AddFile("testuser", models.FileInfo{InboxPath: path, Size: 1024})
+	}
+
+	cfg := &config.Config{
+		DatasetFolder:              "DATASET_ABC",
+		DatasetID:                  "aa-Dataset-abc",
+		UserID:                     "testuser",
+		ClientApiHost:              sda.URL,
+		ClientRetryStatusCodes:     []int{http.StatusServiceUnavailable},
+		ClientRetryMaxAttempts:     3,
+		ClientRetryInitialInterval: time.Millisecond,
+		Timeout:                    1,
+		DatasetChunkSize:           2,
+	}
+	return sda, cfg, db
+}
+
+func TestJob(t *testing.T) {
+	t.Run("Complete", func(t *testing.T) {
+		sda, cfg, _ := newFakeSDA(t)
+		sda.Fail(fakesda.Failure{Method: http.MethodPost, Path: "dataset/create", Status: http.StatusServiceUnavailable, Times: 1})
+		opts := Options{ExpectedFiles: 3, DataDirectory: t.TempDir(), Source: source.KindAPI}
+
+		rep, err := Run(cfg, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Status != report.StatusSucceeded || rep.Retries != 1 {
+			t.Errorf("expected the job to succeed after 1 retry, got %s after %d", rep.Status, rep.Retries)
+		}
+
+		dataset := sda.Dataset(cfg.DatasetID)
+		if dataset == nil || len(dataset.AccessionIDs) != 3 {
+			t.Fatalf("expected a dataset with 3 files, got %+v", dataset)
+		}
+		saved, err := os.ReadFile(helpers.GetFileIDsPath(opts.DataDirectory, cfg.DatasetFolder))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Fields(string(saved)); !slices.Equal(lines, dataset.AccessionIDs) {
+			t.Errorf("expected the file ids file to list %v, got %v", dataset.AccessionIDs, lines)
+		}
+		if requests := sda.Requests()["POST /dataset/create"]; requests != 3 {
+			t.Errorf("expected 2 chunks and a retry, got %d dataset/create requests", requests)
+		}
+	})
+
+	t.Run("Resume after failed dataset creation", func(t *testing.T) {
+		sda, cfg, _ := newFakeSDA(t)
+		sda.Fail(fakesda.Failure{Method: http.MethodPost, Path: "dataset/create", Status: http.StatusBadRequest, Body: `"dataset could not be created"`, Times: 1})
+		opts := Options{ExpectedFiles: 3, DataDirectory: t.TempDir(), Source: source.KindAPI}
+
+		rep, err := Run(cfg, opts)
+		if err == nil {
+			t.Fatal("expected the job to fail")
+		}
+		if rep.Failure == nil || rep.Failure.Step != "dataset" {
+			t.Fatalf("expected the dataset step to fail, got %+v", rep.Failure)
+		}
+
+		opts.Resume = true
+		if _, err := Run(cfg, opts); err != nil {
+			t.Fatal(err)
+		}
+		if dataset := sda.Dataset(cfg.DatasetID); dataset == nil || len(dataset.AccessionIDs) != 3 {
+			t.Errorf("expected the resumed job to complete the dataset, got %+v", dataset)
+		}
+		if requests := sda.Requests()["POST /file/ingest"]; requests != 3 {
+			t.Errorf("expected the files to be ingested once, got %d ingest requests", requests)
+		}
+
+		store, err := history.Open(helpers.GetHistoryPath(opts.DataDirectory))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close() //nolint:errcheck
+		runs, err := store.List(history.Filter{DatasetID: cfg.DatasetID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) != 2 || runs[0].Status != report.StatusSucceeded || runs[1].FailedStep != "dataset" {
+			t.Errorf("expected the failed and the resumed run in the history, got %+v", runs)
+		}
+	})
+
+	t.Run("Resume after failed accession", func(t *testing.T) {
+		sda, cfg, _ := newFakeSDA(t)
+		sda.Fail(fakesda.Failure{Method: http.MethodPost, Path: "file/accession", Status: http.StatusBadRequest, Body: `"accession could not be stored"`, Times: 1, After: 1})
+		opts := Options{ExpectedFiles: 3, DataDirectory: t.TempDir(), Source: source.KindAPI}
+
+		rep, err := Run(cfg, opts)
+		if err == nil {
+			t.Fatal("expected the job to fail")
+		}
+		if rep.Failure == nil || rep.Failure.Step != "accession" {
+			t.Fatalf("expected the accession step to fail, got %+v", rep.Failure)
+		}
+		saved, err := dataset.ReadStableIDsFile(helpers.GetStableIDsPath(opts.DataDirectory, cfg.DatasetFolder))
+		if err != nil || len(saved) != 1 {
+			t.Fatalf("expected the accession id of the first file to be saved, got %v, %v", saved, err)
+		}
+
+		opts.Resume = true
+		if _, err := Run(cfg, opts); err != nil {
+			t.Fatal(err)
+		}
+		dataset := sda.Dataset(cfg.DatasetID)
+		if dataset == nil || len(dataset.AccessionIDs) != 3 || !slices.Contains(dataset.AccessionIDs, saved[0].AccessionID) {
+			t.Errorf("expected the resumed job to complete the dataset with the saved id, got %+v", dataset)
+		}
+		if requests := sda.Requests()["POST /file/accession"]; requests != 4 {
+			t.Errorf("expected the failed file to be accessioned again and the saved one not, got %d accession requests", requests)
+		}
+	})
+
+	t.Run("Unexpected number of files", func(t *testing.T) {
+		sda, cfg, _ := newFakeSDA(t)
+		opts := Options{ExpectedFiles: 4, DataDirectory: t.TempDir(), Source: source.KindAPI}
+
+		if _, err := Run(cfg, opts); err == nil {
+			t.Error("expected the job to fail")
+		}
+		if requests := sda.Requests()["POST /file/ingest"]; requests != 0 {
+			t.Errorf("expected no files to be ingested, got %d ingest requests", requests)
+		}
+	})
+}
+
+func TestJobWithRepository(t *testing.T) {
+	t.Run("Notify and release", func(t *testing.T) {
+		sda, cfg, db := newFakeSDA(t)
+		outputDir := t.TempDir()
+		cfg.MailAddress = "ops@example.com"
+		cfg.MailTransport = "file"
+		cfg.MailOutputDir = outputDir
+		cfg.MailRecipients = []config.Recipient{{Name: "Submitter", To: []string{"submitter@example.com"}, Template: "notify-submitter.html"}}
+		opts := Options{ExpectedFiles: 3, DataDirectory: t.TempDir(), Source: source.KindDB, Notify: true, Release: true, DB: db}
+
+		if _, err := Run(cfg, opts); err != nil {
+			t.Fatal(err)
+		}
+		if dataset := sda.Dataset(cfg.DatasetID); dataset == nil || dataset.Status != fakesda.DatasetReleased {
+			t.Errorf("expected the dataset to be released, got %+v", dataset)
+		}
+
+		stableIDs, err := dataset.ReadStableIDsFile(helpers.GetStableIDsPath(opts.DataDirectory, cfg.DatasetFolder))
+		if err != nil || len(stableIDs) != 3 {
+			t.Errorf("expected the stable ids of the 3 files in the dataset, got %+v, %v", stableIDs, err)
+		}
+		if mails, _ := filepath.Glob(filepath.Join(outputDir, "*.eml")); len(mails) != 1 {
+			t.Errorf("expected 1 completion mail, got %d", len(mails))
+		}
+	})
+
+	t.Run("Append", func(t *testing.T) {
+		sda, cfg, db := newFakeSDA(t)
+		opts := Options{ExpectedFiles: 3, DataDirectory: t.TempDir(), Source: source.KindDB, DB: db}
+		if _, err := Run(cfg, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		sda.AddFile("testuser", models.FileInfo{InboxPath: "DATASET_ABC/IMAGES/e.c4gh", Size: 1024})
+		opts.ExpectedFiles = 1
+		opts.Append = true
+		rep, err := Run(cfg, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Membership == nil || rep.Membership.Before != 3 || rep.Membership.After != 4 || !slices.Equal(rep.Membership.Added, []string{"DATASET_ABC/IMAGES/e.c4gh"}) {
+			t.Errorf("expected e.c4gh to be added to the 3 files in the dataset, got %+v", rep.Membership)
+		}
+		if dataset := sda.Dataset(cfg.DatasetID); dataset == nil || len(dataset.AccessionIDs) != 4 {
+			t.Errorf("expected a dataset with 4 files, got %+v", dataset)
+		}
+	})
+
+	t.Run("Append to existing dataset", func(t *testing.T) {
+		sda, cfg, db := newFakeSDAWith(t, fakesda.Options{VerifyAfter: 2, DatasetConflict: true})
+		opts := Options{ExpectedFiles: 3, DataDirectory: t.TempDir(), Source: source.KindDB, DB: db}
+		if _, err := Run(cfg, opts); err != nil {
+			t.Fatalf("expected the second chunk to be added to the dataset created by the first, got %v", err)
+		}
+
+		sda.AddFile("testuser", models.FileInfo{InboxPath: "DATASET_ABC/IMAGES/e.c4gh", Size: 1024})
+		opts.ExpectedFiles = 1
+		opts.Append = true
+		if _, err := Run(cfg, opts); err != nil {
+			t.Fatal(err)
+		}
+		if dataset := sda.Dataset(cfg.DatasetID); dataset == nil || len(dataset.AccessionIDs) != 4 {
+			t.Errorf("expected a dataset with 4 files, got %+v", dataset)
+		}
+		if requests := sda.Requests(); requests["POST /dataset/create"] != 3 {
+			t.Errorf("expected 3 dataset chunks to be sent, got %d", requests["POST /dataset/create"])
+		}
+	})
+
+	t.Run("Append to missing dataset", func(t *testing.T) {
+		_, cfg, db := newFakeSDA(t)
+		opts := Options{ExpectedFiles: 3, DataDirectory: t.TempDir(), Source: source.KindDB, Append: true, DB: db}
+
+		rep, err := Run(cfg, opts)
+		if err == nil || rep.Failure == nil || rep.Failure.Step != "membership" {
+			t.Errorf("expected the membership step to fail, got %v", err)
+		}
+	})
+}
+
+func TestValidateOptions(t *testing.T) {
+	if err := (Options{Artifacts: true}).Validate(); err == nil {
+		t.Error("expected --artifacts without --notify to be rejected")
+	}
+	if err := (Options{Artifacts: true, Notify: true}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,161 @@
+package lint
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/NBISweden/submitter/cmd"
+	"github.com/NBISweden/submitter/helpers"
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/models"
+	"github.com/NBISweden/submitter/internal/source"
+	"github.com/spf13/cobra"
+)
+
+var configPath string
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [flags]",
+	Short: "Check the folder structure of a dataset",
+	Long:  "Check that the uploaded files of the dataset follow the configured folder structure, have allowed extensions and are not empty",
+	Args: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := cmd.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+
+		src, closeSource, err := source.Open(cmd.SourceKind(source.KindDB), cfg)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+
+		files, err := source.Files(src)
+		if err != nil {
+			return err
+		}
+
+		violations := NewRules(cfg).Check(files, cfg.DatasetFolder)
+		for _, v := range violations {
+			fmt.Println(v)
+		}
+		slog.Info("checked dataset structure", "files", len(files), "violations", len(violations))
+
+		return Err(violations)
+	},
+}
+
+func init() {
+	cmd.AddCommand(lintCmd)
+	lintCmd.Flags().StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
+}
+
+// Rule names reported in violations
+const (
+	RuleRequiredDir = "required-dir"
+	RuleAllowedDir  = "allowed-dir"
+	RuleExtension   = "extension"
+	RuleEmptyFile   = "empty-file"
+)
+
+// Violation is a file, or a missing folder, that breaks a structure rule
+type Violation struct {
+	Path    string
+	Rule    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: [%s] %s", v.Path, v.Rule, v.Message)
+}
+
+// Rules describe the expected structure of a dataset folder
+type Rules struct {
+	RequiredDirs      []string
+	AllowedDirs       []string
+	AllowedExtensions []string
+	AllowEmptyFiles   bool
+}
+
+// NewRules returns the structure rules from STRUCTURE_* in the configuration
+func NewRules(cfg *config.Config) *Rules {
+	rules := &Rules{
+		RequiredDirs:      cfg.StructureRequiredDirs,
+		AllowedDirs:       cfg.StructureAllowedDirs,
+		AllowedExtensions: cfg.StructureAllowedExtensions,
+		AllowEmptyFiles:   cfg.StructureAllowEmptyFiles,
+	}
+	if len(rules.AllowedDirs) == 0 {
+		rules.AllowedDirs = rules.RequiredDirs
+	}
+	return rules
+}
+
+// Check returns every violation of the rules for the files in the dataset folder
+func (r *Rules) Check(files []models.FileInfo, datasetFolder string) []Violation {
+	var violations []Violation
+	dirs := map[string]int{}
+
+	for _, f := range files {
+		if f.Status == "disabled" {
+			continue
+		}
+
+		rel := helpers.RelativeToFolder(f.InboxPath, datasetFolder)
+		dir, _, nested := strings.Cut(rel, "/")
+		if nested {
+			dirs[dir]++
+		}
+
+		switch {
+		case !nested && len(r.AllowedDirs) > 0:
+			violations = append(violations, Violation{rel, RuleAllowedDir, "file is not inside any of the folders " + quoteAll(r.AllowedDirs)})
+		case nested && len(r.AllowedDirs) > 0 && !slices.Contains(r.AllowedDirs, dir):
+			violations = append(violations, Violation{rel, RuleAllowedDir, fmt.Sprintf("folder %q is not one of %s", dir, quoteAll(r.AllowedDirs))})
+		}
+
+		if len(r.AllowedExtensions) > 0 && !slices.ContainsFunc(r.AllowedExtensions, func(ext string) bool { return strings.HasSuffix(rel, ext) }) {
+			violations = append(violations, Violation{rel, RuleExtension, "file extension is not one of " + quoteAll(r.AllowedExtensions)})
+		}
+
+		if !r.AllowEmptyFiles && f.Size == 0 {
+			violations = append(violations, Violation{rel, RuleEmptyFile, "file is empty"})
+		}
+	}
+
+	for _, dir := range r.RequiredDirs {
+		if dirs[dir] == 0 {
+			violations = append(violations, Violation{dir + "/", RuleRequiredDir, "required folder is missing or empty"})
+		}
+	}
+
+	return violations
+}
+
+// Err returns nil if there are no violations, otherwise an error listing all of them
+func Err(violations []Violation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "dataset structure check failed with %d violations:", len(violations))
+	for _, v := range violations {
+		fmt.Fprintf(&b, "\n  - %s", v)
+	}
+	return errors.New(b.String())
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, fmt.Sprintf("%q", v))
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,55 @@
+package lint
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+func TestCheck(t *testing.T) {
+	rules := &Rules{
+		RequiredDirs:      []string{"METADATA", "IMAGES", "PRIVATE"},
+		AllowedDirs:       []string{"METADATA", "IMAGES", "PRIVATE", "LANDING PAGE"},
+		AllowedExtensions: []string{".c4gh"},
+	}
+
+	t.Run("Valid dataset", func(t *testing.T) {
+		files := []models.FileInfo{
+			{InboxPath: "/user/DATASET_ABC/METADATA/dataset.xml.c4gh", Size: 10},
+			{InboxPath: "/user/DATASET_ABC/IMAGES/image-1/slide.dcm.c4gh", Size: 10},
+			{InboxPath: "/user/DATASET_ABC/PRIVATE/files.csv.c4gh", Size: 10},
+			{InboxPath: "/user/DATASET_ABC/stray.txt", Status: "disabled"},
+		}
+		if violations := rules.Check(files, "DATASET_ABC"); len(violations) != 0 {
+			t.Errorf("expected no violations, got %v", violations)
+		}
+	})
+
+	t.Run("Violations", func(t *testing.T) {
+		files := []models.FileInfo{
+			{InboxPath: "/user/DATASET_ABC/METADATA/dataset.xml.c4gh", Size: 10},
+			{InboxPath: "/user/DATASET_ABC/IMAGES/slide.dcm", Size: 10},
+			{InboxPath: "/user/DATASET_ABC/IMAGES/empty.dcm.c4gh"},
+			{InboxPath: "/user/DATASET_ABC/EXTRA/notes.txt.c4gh", Size: 10},
+			{InboxPath: "/user/DATASET_ABC/readme.c4gh", Size: 10},
+		}
+
+		violations := rules.Check(files, "DATASET_ABC")
+		rules := map[string]int{}
+		for _, v := range violations {
+			rules[v.Rule]++
+		}
+		expected := map[string]int{RuleExtension: 1, RuleEmptyFile: 1, RuleAllowedDir: 2, RuleRequiredDir: 1}
+		for rule, count := range expected {
+			if rules[rule] != count {
+				t.Errorf("expected %d %s violations, got %d: %v", count, rule, rules[rule], violations)
+			}
+		}
+
+		err := Err(violations)
+		if err == nil || !strings.Contains(err.Error(), "PRIVATE/: [required-dir]") {
+			t.Errorf("expected missing PRIVATE folder in error, got %v", err)
+		}
+	})
+}
@@ -1,42 +1,80 @@
 package mail
 
 import (
-	"bytes"
-	"embed"
+	"bufio"
 	"fmt"
-	"html/template"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/NBISweden/submitter/cmd"
+	"github.com/NBISweden/submitter/helpers"
+	"github.com/NBISweden/submitter/internal/attachments"
 	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/database"
+	"github.com/NBISweden/submitter/internal/history"
+	"github.com/NBISweden/submitter/internal/notify"
+	"github.com/NBISweden/submitter/internal/report"
 	"github.com/spf13/cobra"
 	"gopkg.in/gomail.v2"
 )
 
-//go:embed templates/*.html
-var templateFS embed.FS
 var dryRun bool
 var configPath string
 var dataDirectory string
+var event string
+
+// Events that recipients can subscribe to through EVENTS in MAIL_RECIPIENTS
+const (
+	EventJobFinished = notify.EventJobFinished
+	EventJobFailed   = notify.EventJobFailed
+)
 
 var mailCmd = &cobra.Command{
 	Use:   "mail",
 	Short: "Send mail notifications",
-	Long:  "Send mail notifications",
-	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.NewConfig(configPath)
+	Long:  "Send mail notifications to all recipients in MAIL_RECIPIENTS that are subscribed to the given event",
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := cmd.LoadConfig(configPath)
 		if err != nil {
 			return err
 		}
-		m := New(cfg)
-		for _, recipient := range []string{"BigPicture", "Minttu", "Submitter"} {
-			if err := m.Notify(recipient, dryRun); err != nil {
-				return fmt.Errorf("failed to notify %s: %w", recipient, err)
-			}
+		m, err := New(cfg, dataDirectory)
+		if err != nil {
+			return err
 		}
 
-		return nil
+		data := m.Data()
+		data.AccessionFile = filepath.Base(helpers.GetFileIDsPath(dataDirectory, cfg.DatasetFolder))
+		data.FileCount, err = countLines(helpers.GetStableIDsPath(dataDirectory, cfg.DatasetFolder))
+		if err != nil {
+			slog.Warn("[mail] could not count files in stable ids file", "err", err)
+		}
+		data.TotalSize, err = datasetSize(cfg)
+		if err != nil {
+			slog.Warn("[mail] could not get the size of the dataset from the database", "err", err)
+		}
+		m.SetData(data)
+
+		// A dry run only mails the address in the configuration and is not recorded
+		if dryRun {
+			return m.Notify(event, dryRun)
+		}
+		return history.RecordCommand(dataDirectory, "mail", cfg, func(rep *report.Report) error {
+			step := rep.StartStep("mail")
+			deliveries := m.Deliver(event, false)
+			for _, delivery := range deliveries {
+				rep.AddNotification("mail", delivery.Name, event, delivery.Err)
+			}
+			if err := deliveryErr(event, deliveries); err != nil {
+				return err
+			}
+			step.Finish(len(deliveries), nil)
+
+			return nil
+		})
 	},
 }
 
@@ -44,74 +82,85 @@ func init() {
 	cmd.AddCommand(mailCmd)
 	mailCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Toggles dry-run mode. Dry run will send all emails to the address in configuration.Email (env or yaml conf)")
 	mailCmd.Flags().StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
-	mailCmd.Flags().StringVar(&dataDirectory, "data-directory", "data", "Directory to retrieve files from to attach in mail notifications")
+	mailCmd.Flags().StringVar(&dataDirectory, "data-directory", "data", "Directory to retrieve files from to attach in mail notifications, and holding the history")
+	mailCmd.Flags().StringVar(&event, "event", EventJobFinished, "Event to notify recipients about")
 }
 
 type Mail struct {
-	smtpHost string
-	smtpPort int
-	email    string
-	password string
-	from     string
-	data     TemplateData
-	lookup   map[string]Notifiers
-}
-
-type TemplateData struct {
-	Uploader      string
-	DatasetID     string
-	DatasetFolder string
+	transport     gomail.Sender
+	email         string
+	from          string
+	dataDirectory string
+	templateDir   string
+	data          TemplateData
+	recipients    []Notifiers
 }
 
 type Notifiers struct {
-	email       string
+	name        string
+	to          []string
 	cc          []string
+	bcc         []string
 	template    string
 	subject     string
 	attachments []string
+	events      []string
 }
 
-func New(c *config.Config) *Mail {
+func New(c *config.Config, dataDirectory string) (*Mail, error) {
+	transport, err := newTransport(c)
+	if err != nil {
+		return nil, err
+	}
+
 	m := &Mail{
-		smtpHost: c.MailSmtpHost,
-		smtpPort: c.MailSmtpPort,
-		email:    c.MailAddress,
-		password: c.MailPassword,
-		from:     c.MailAddress,
+		transport:     transport,
+		email:         c.MailAddress,
+		from:          c.MailAddress,
+		dataDirectory: dataDirectory,
+		templateDir:   c.MailTemplateDir,
 		data: TemplateData{
 			Uploader:      c.MailUploaderName,
+			UploaderEmail: c.MailUploader,
 			DatasetID:     c.DatasetID,
 			DatasetFolder: c.DatasetFolder,
 		},
-		lookup: map[string]Notifiers{
-			"Submitter": {
-				email:       c.MailUploader,
-				template:    "notify-submitter.html",
-				subject:     "Successful Ingestion of Your Dataset Submission",
-				attachments: []string{fmt.Sprintf("%s/%s-stableIDs.txt", dataDirectory, c.DatasetFolder)},
-			},
-			"BigPicture": {
-				email:       "submit@bigpicture.eu",
-				template:    "notify-bigpicture.html",
-				subject:     fmt.Sprintf("Dataset %s has been ingested", c.DatasetFolder),
-				attachments: []string{fmt.Sprintf("%s/dataset.txt", dataDirectory), fmt.Sprintf("%s/policy.txt", dataDirectory)},
-			},
-			"Minttu": {
-				email:       "minttu.sauramo@hus.fi",
-				cc:          []string{"jarno.laitinen@csc.fi"},
-				template:    "notify-minttu.html",
-				subject:     fmt.Sprintf("Dataset %s has been ingested", c.DatasetFolder),
-				attachments: []string{fmt.Sprintf("%s/dataset.txt", dataDirectory), fmt.Sprintf("%s/rems.txt", dataDirectory), fmt.Sprintf("%s/policy.txt", dataDirectory)},
-			},
-		},
 	}
-	return m
+
+	for _, r := range c.MailRecipients {
+		events := r.Events
+		if len(events) == 0 {
+			events = []string{EventJobFinished}
+		}
+		m.recipients = append(m.recipients, Notifiers{
+			name:        r.Name,
+			to:          r.To,
+			cc:          r.Cc,
+			bcc:         r.Bcc,
+			template:    r.Template,
+			subject:     r.Subject,
+			attachments: r.Attachments,
+			events:      events,
+		})
+	}
+
+	return m, nil
+}
+
+// Data returns the data that templates are rendered with
+func (mail *Mail) Data() TemplateData {
+	return mail.data
 }
 
-func (mail *Mail) send(subject string, message string, reciever string, attachements []string, ccs []string) error {
+// SetData replaces the data that templates are rendered with
+func (mail *Mail) SetData(data TemplateData) {
+	mail.data = data
+}
+
+func (mail *Mail) send(subject string, message string, plainText string, recievers []string, attachements []string, ccs []string, bccs []string) error {
 	m := gomail.NewMessage()
 	m.SetHeader("From", mail.from)
-	m.SetHeader("To", reciever)
+	m.SetHeader("To", recievers...)
 	m.SetHeader("Subject", subject)
 
 	if len(ccs) > 0 {
@@ -122,53 +171,139 @@ func (mail *Mail) send(subject string, message string, reciever string, attachem
 		m.SetHeader("Cc", addresses...)
 	}
 
-	m.SetBody("text/html", message)
+	if len(bccs) > 0 {
+		m.SetHeader("Bcc", bccs...)
+	}
+
+	m.SetBody("text/plain", plainText)
+	m.AddAlternative("text/html", message)
 
 	// Enforce that the wanted attachements are files that exists
 	if err := attachementsExists(attachements); err != nil {
 		return err
 	}
+	for _, file := range attachements {
+		if attachments.IsArtifact(file) {
+			if err := attachments.ValidateFile(file, mail.data.DatasetID); err != nil {
+				return err
+			}
+		}
+	}
 	for _, file := range attachements {
 		m.Attach(file)
 	}
 
-	d := gomail.NewDialer(mail.smtpHost, mail.smtpPort, mail.email, mail.password)
-	slog.Info("[mail] notification sent about dataset completion", "reciever", reciever)
-	return d.DialAndSend(m)
+	if err := gomail.Send(mail.transport, m); err != nil {
+		return err
+	}
+	slog.Info("[mail] notification sent about dataset completion", "recievers", recievers)
+
+	return nil
 }
 
-func (mail *Mail) Notify(notifier string, dryRun bool) error {
-	htmlBody, err := renderTemplate(mail.lookup[notifier].template, mail.data)
+// HasRecipients reports whether any recipient is subscribed to event
+func (mail *Mail) HasRecipients(event string) bool {
+	for _, recipient := range mail.recipients {
+		if slices.Contains(recipient.events, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify sends a mail to every configured recipient that is subscribed to event
+func (mail *Mail) Notify(event string, dryRun bool) error {
+	return deliveryErr(event, mail.Deliver(event, dryRun))
+}
+
+// deliveryErr returns an error if there were no recipients for event or a mail failed
+func deliveryErr(event string, deliveries []notify.Delivery) error {
+	if len(deliveries) == 0 {
+		return fmt.Errorf("no mail recipients configured for event %s", event)
+	}
+
+	for _, delivery := range deliveries {
+		if delivery.Err != nil {
+			return fmt.Errorf("failed to notify %s: %w", delivery.Name, delivery.Err)
+		}
+	}
+
+	return nil
+}
+
+// Deliver sends a mail to every recipient subscribed to event, also when sending to one of
+// them fails, and returns the outcome per recipient
+func (mail *Mail) Deliver(event string, dryRun bool) []notify.Delivery {
+	var deliveries []notify.Delivery
+	for _, recipient := range mail.recipients {
+		if !slices.Contains(recipient.events, event) {
+			continue
+		}
+
+		err := mail.notifyRecipient(recipient, dryRun)
+		if err != nil {
+			slog.Warn("[mail] could not notify recipient", "recipient", recipient.name, "err", err)
+		}
+		deliveries = append(deliveries, notify.Delivery{Name: recipient.name, Err: err})
+	}
+
+	return deliveries
+}
+
+func (mail *Mail) notifyRecipient(recipient Notifiers, dryRun bool) error {
+	htmlBody, err := renderTemplate(mail.templateDir, recipient.template, mail.data)
 	if err != nil {
 		return fmt.Errorf("failed to render mail template: %v", err)
 	}
 
-	if dryRun {
-		slog.Info(fmt.Sprintf("[mail] dry-run enabled, using <%s> instead of <%s>", mail.email, mail.lookup[notifier].email))
-		err = mail.send(mail.lookup[notifier].subject, htmlBody, mail.email, mail.lookup[notifier].attachments, nil)
+	plainText, err := renderPlainText(mail.templateDir, recipient.template, htmlBody, mail.data)
+	if err != nil {
+		return fmt.Errorf("failed to render plain text mail template: %v", err)
 	}
 
-	if !dryRun {
-		err = mail.send(mail.lookup[notifier].subject, htmlBody, mail.lookup[notifier].email, mail.lookup[notifier].attachments, mail.lookup[notifier].cc)
+	subject, err := renderString(recipient.subject, mail.data)
+	if err != nil {
+		return fmt.Errorf("failed to render mail subject: %v", err)
 	}
+
+	to, err := renderStrings(recipient.to, mail.data)
 	if err != nil {
-		return fmt.Errorf("failed to send mail notification %v", err)
+		return fmt.Errorf("failed to render mail recievers: %v", err)
 	}
 
-	return nil
-}
+	cc, err := renderStrings(recipient.cc, mail.data)
+	if err != nil {
+		return fmt.Errorf("failed to render mail cc: %v", err)
+	}
 
-func renderTemplate(filename string, data TemplateData) (string, error) {
-	tmpl, err := template.ParseFS(templateFS, "templates/"+filename)
+	bcc, err := renderStrings(recipient.bcc, mail.data)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to render mail bcc: %v", err)
+	}
+
+	attachments, err := renderStrings(recipient.attachments, mail.data)
+	if err != nil {
+		return fmt.Errorf("failed to render mail attachments: %v", err)
+	}
+	for i, attachment := range attachments {
+		if !filepath.IsAbs(attachment) {
+			attachments[i] = filepath.Join(mail.dataDirectory, attachment)
+		}
+	}
+
+	if dryRun {
+		slog.Info(fmt.Sprintf("[mail] dry-run enabled, using <%s> instead of <%s>", mail.email, strings.Join(to, ", ")))
+		err = mail.send(subject, htmlBody, plainText, []string{mail.email}, attachments, nil, nil)
 	}
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", err
+
+	if !dryRun {
+		err = mail.send(subject, htmlBody, plainText, to, attachments, cc, bcc)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send mail notification %v", err)
 	}
 
-	return buf.String(), nil
+	return nil
 }
 
 func attachementsExists(attachements []string) error {
@@ -192,3 +327,41 @@ func attachementsExists(attachements []string) error {
 
 	return nil
 }
+
+func countLines(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	var lines int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "" {
+			lines++
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// datasetSize sums the sizes of the files in DATASET_ID as recorded in the database
+func datasetSize(cfg *config.Config) (int64, error) {
+	db, err := database.New(cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	files, err := db.GetDatasetFiles(cfg.DatasetID)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, f := range files {
+		size += f.Size
+	}
+	return size, nil
+}
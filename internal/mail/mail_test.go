@@ -0,0 +1,135 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/config"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	data := TemplateData{
+		Uploader:      "Jane Doe",
+		DatasetID:     "aa-Dataset-abc",
+		DatasetFolder: "DATASET_ABC",
+		FileCount:     12,
+		TotalSize:     3 * 1024 * 1024,
+	}
+
+	t.Run("Embedded template with generated plain text", func(t *testing.T) {
+		htmlBody, err := renderTemplate("", "notify-bigpicture.html", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(htmlBody, "3.0 MiB") {
+			t.Errorf("expected total size in html body: %s", htmlBody)
+		}
+
+		text, err := renderPlainText("", "notify-bigpicture.html", htmlBody, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(text, "<") || !strings.Contains(text, "- Dataset ID: aa-Dataset-abc") {
+			t.Errorf("unexpected plain text body: %s", text)
+		}
+	})
+
+	t.Run("Template directory overrides embedded template", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "notify-bigpicture.html"), []byte("<p>{{.DatasetID}} custom</p>"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "notify-bigpicture.txt"), []byte("{{.DatasetID}} plain"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		htmlBody, err := renderTemplate(dir, "notify-bigpicture.html", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if htmlBody != "<p>aa-Dataset-abc custom</p>" {
+			t.Errorf("expected template from directory, got %s", htmlBody)
+		}
+
+		text, err := renderPlainText(dir, "notify-bigpicture.html", htmlBody, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != "aa-Dataset-abc plain" {
+			t.Errorf("expected plain text template from directory, got %s", text)
+		}
+	})
+}
+
+func TestNotifyWithFileTransport(t *testing.T) {
+	dataDir := t.TempDir()
+	outputDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dataDir, "DATASET_ABC-stableIDs.txt"), []byte("aa-File-1 DATASET_ABC/file1.c4gh\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		DatasetFolder:    "DATASET_ABC",
+		DatasetID:        "aa-Dataset-abc",
+		MailAddress:      "ops@example.com",
+		MailUploader:     "jane@example.com",
+		MailUploaderName: "Jane Doe",
+		MailTransport:    TransportFile,
+		MailOutputDir:    outputDir,
+		MailRecipients: []config.Recipient{
+			{
+				Name:        "Submitter",
+				To:          []string{"{{.UploaderEmail}}"},
+				Bcc:         []string{"archive@example.com"},
+				Template:    "notify-submitter.html",
+				Subject:     "Dataset {{.DatasetID}} ingested",
+				Attachments: []string{"{{.DatasetFolder}}-stableIDs.txt"},
+				Events:      []string{EventJobFinished},
+			},
+			{
+				Name:     "Other",
+				To:       []string{"other@example.com"},
+				Template: "notify-bigpicture.html",
+				Events:   []string{"some_other_event"},
+			},
+		},
+	}
+
+	m, err := New(cfg, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Notify(EventJobFinished, false); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(outputDir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 mail to be written, got %d", len(files))
+	}
+
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := string(content)
+	for _, expected := range []string{
+		"X-Envelope-To: jane@example.com, archive@example.com",
+		"Subject: Dataset aa-Dataset-abc ingested",
+		"multipart/alternative",
+		"text/plain",
+		"DATASET_ABC-stableIDs.txt",
+	} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("expected %q in written mail", expected)
+		}
+	}
+	if strings.Contains(message, "\r\nBcc:") {
+		t.Error("Bcc header should not be part of the written mail")
+	}
+}
@@ -0,0 +1,148 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"html"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/NBISweden/submitter/helpers"
+)
+
+//go:embed templates/*
+var templateFS embed.FS
+
+type TemplateData struct {
+	Uploader      string
+	UploaderEmail string
+	DatasetID     string
+	DatasetFolder string
+	FileCount     int
+	TotalSize     int64
+	AccessionFile string
+	JobStartedAt  time.Time
+	JobFinishedAt time.Time
+	Failure       *Failure
+}
+
+// Failure describes why a job failed, for templates that report errors
+type Failure struct {
+	Step     string
+	Error    string
+	Problems []string
+	Progress []string
+}
+
+var templateFuncs = map[string]any{
+	"humanSize": helpers.HumanSize,
+}
+
+// readTemplate returns the template named filename from templateDir if it exists there,
+// otherwise from the embedded default templates
+func readTemplate(templateDir string, filename string) ([]byte, error) {
+	if templateDir != "" {
+		content, err := os.ReadFile(filepath.Join(templateDir, filename))
+		if err == nil {
+			return content, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return templateFS.ReadFile("templates/" + filename)
+}
+
+func renderTemplate(templateDir string, filename string, data TemplateData) (string, error) {
+	content, err := readTemplate(templateDir, filename)
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New(filename).Funcs(templateFuncs).Parse(string(content))
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// renderPlainText renders the text/plain alternative of a mail. A template with the same
+// name as the html template but with a .txt extension is used when one exists, otherwise
+// the text is generated from the rendered html.
+func renderPlainText(templateDir string, filename string, htmlBody string, data TemplateData) (string, error) {
+	textFilename := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".txt"
+	content, err := readTemplate(templateDir, textFilename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return htmlToText(htmlBody), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return renderString(string(content), data)
+}
+
+func renderString(text string, data TemplateData) (string, error) {
+	tmpl, err := texttemplate.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func renderStrings(texts []string, data TemplateData) ([]string, error) {
+	rendered := make([]string, 0, len(texts))
+	for _, text := range texts {
+		r, err := renderString(text, data)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, r)
+	}
+
+	return rendered, nil
+}
+
+var (
+	headRegexp       = regexp.MustCompile(`(?is)<(head|style|script)[^>]*>.*?</(head|style|script)>`)
+	lineBreakRegexp  = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|h[1-6]|ul|ol|li|tr)>`)
+	listItemRegexp   = regexp.MustCompile(`(?i)<li[^>]*>`)
+	tagRegexp        = regexp.MustCompile(`<[^>]*>`)
+	blankLinesRegexp = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText makes a readable plain text version of a rendered html mail
+func htmlToText(htmlBody string) string {
+	text := headRegexp.ReplaceAllString(htmlBody, "")
+	text = listItemRegexp.ReplaceAllString(text, "- ")
+	text = lineBreakRegexp.ReplaceAllString(text, "\n")
+	text = tagRegexp.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	text = strings.Join(lines, "\n")
+	text = blankLinesRegexp.ReplaceAllString(text, "\n\n")
+
+	return strings.TrimSpace(text) + "\n"
+}
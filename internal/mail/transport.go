@@ -0,0 +1,216 @@
+package mail
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/NBISweden/submitter/internal/config"
+	"gopkg.in/gomail.v2"
+)
+
+// Transports selectable through MAIL_TRANSPORT
+const (
+	TransportSMTP     = "smtp"
+	TransportSendmail = "sendmail"
+	TransportFile     = "file"
+)
+
+// TLS modes selectable through MAIL_SMTP_TLS
+const (
+	SmtpTLSStartTLS = "starttls"
+	SmtpTLSImplicit = "implicit"
+	SmtpTLSNone     = "none"
+)
+
+// newTransport returns the gomail.Sender that delivers messages for the configured transport
+func newTransport(c *config.Config) (gomail.Sender, error) {
+	switch c.MailTransport {
+	case "", TransportSMTP:
+		return newSmtpTransport(c)
+	case TransportSendmail:
+		return &sendmailTransport{path: c.MailSendmailPath}, nil
+	case TransportFile:
+		if c.MailOutputDir == "" {
+			return nil, fmt.Errorf("MAIL_OUTPUT_DIR requiered for the %s mail transport", TransportFile)
+		}
+		return &fileTransport{dir: c.MailOutputDir}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", c.MailTransport)
+	}
+}
+
+type smtpTransport struct {
+	host      string
+	port      int
+	username  string
+	password  string
+	tlsMode   string
+	tlsConfig *tls.Config
+}
+
+func newSmtpTransport(c *config.Config) (*smtpTransport, error) {
+	t := &smtpTransport{
+		host:      c.MailSmtpHost,
+		port:      c.MailSmtpPort,
+		tlsMode:   c.MailSmtpTLS,
+		tlsConfig: &tls.Config{ServerName: c.MailSmtpHost, MinVersion: tls.VersionTLS12},
+	}
+
+	if !c.MailSmtpNoAuth {
+		t.username = c.MailAddress
+		t.password = c.MailPassword
+	}
+
+	switch t.tlsMode {
+	case "":
+		t.tlsMode = SmtpTLSStartTLS
+	case SmtpTLSStartTLS, SmtpTLSImplicit, SmtpTLSNone:
+	default:
+		return nil, fmt.Errorf("unknown MAIL_SMTP_TLS %q", t.tlsMode)
+	}
+
+	if c.MailSmtpCaCert != "" {
+		caCert, err := os.ReadFile(c.MailSmtpCaCert)
+		if err != nil {
+			return nil, fmt.Errorf("read smtp CA cert: %w", err)
+		}
+
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+			return nil, fmt.Errorf("no certificates found in smtp CA cert %q", c.MailSmtpCaCert)
+		}
+		t.tlsConfig.RootCAs = caCertPool
+	}
+
+	return t, nil
+}
+
+func (t *smtpTransport) Send(from string, to []string, msg io.WriterTo) error {
+	addr := net.JoinHostPort(t.host, strconv.Itoa(t.port))
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	if t.tlsMode == SmtpTLSImplicit {
+		conn = tls.Client(conn, t.tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, t.host)
+	if err != nil {
+		conn.Close() //nolint:errcheck
+		return err
+	}
+	defer c.Close() //nolint:errcheck
+
+	if t.tlsMode == SmtpTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s does not support STARTTLS", addr)
+		}
+		if err := c.StartTLS(t.tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if t.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", t.username, t.password, t.host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := c.Rcpt(address); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := msg.WriteTo(w); err != nil {
+		w.Close() //nolint:errcheck
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+type sendmailTransport struct {
+	path string
+}
+
+func (t *sendmailTransport) Send(from string, to []string, msg io.WriterTo) error {
+	path := t.path
+	if path == "" {
+		path = "/usr/sbin/sendmail"
+	}
+
+	args := append([]string{"-i", "-f", from, "--"}, to...)
+	command := exec.Command(path, args...)
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	var stderr strings.Builder
+	command.Stderr = &stderr
+	if err := command.Start(); err != nil {
+		return fmt.Errorf("could not start sendmail: %w", err)
+	}
+
+	_, writeErr := msg.WriteTo(stdin)
+	stdin.Close() //nolint:errcheck
+	if err := command.Wait(); err != nil {
+		return fmt.Errorf("sendmail failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return writeErr
+}
+
+// fileTransport writes every message as an .eml file, prefixed with the envelope sender and
+// recipients so that Bcc recipients are visible as well
+type fileTransport struct {
+	dir string
+}
+
+var fileTransportCounter atomic.Int64
+
+func (t *fileTransport) Send(from string, to []string, msg io.WriterTo) error {
+	if err := os.MkdirAll(t.dir, 0750); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%03d.eml", time.Now().UTC().Format("20060102T150405.000000000Z"), fileTransportCounter.Add(1))
+	file, err := os.Create(filepath.Join(t.dir, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close() //nolint:errcheck
+
+	if _, err := fmt.Fprintf(file, "X-Envelope-From: %s\r\nX-Envelope-To: %s\r\n", from, strings.Join(to, ", ")); err != nil {
+		return err
+	}
+	if _, err := msg.WriteTo(file); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,407 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/NBISweden/submitter/cmd"
+	"github.com/NBISweden/submitter/helpers"
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/models"
+	"github.com/NBISweden/submitter/internal/source"
+	"github.com/spf13/cobra"
+)
+
+var configPath string
+var metadataDir string
+
+var metadataCmd = &cobra.Command{
+	Use:   "metadata [flags]",
+	Short: "Validate the BigPicture metadata of a dataset",
+	Long:  "Validate the xml files in the METADATA folder of the dataset against the BigPicture XSDs and check that they reference the uploaded files and DATASET_ID",
+	Args: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := cmd.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+
+		src, closeSource, err := source.Open(cmd.SourceKind(source.KindAPI), cfg)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+
+		inbox, err := source.Files(src)
+		if err != nil {
+			return err
+		}
+
+		result, err := Check(cfg, metadataDir, inbox)
+		if err != nil {
+			return err
+		}
+		slog.Info("validated metadata", "files", len(result.Files), "problems", len(result.Problems))
+
+		return result.Err()
+	},
+}
+
+func init() {
+	cmd.AddCommand(metadataCmd)
+	metadataCmd.Flags().StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
+	metadataCmd.Flags().StringVar(&metadataDir, "metadata-dir", "", "Local directory with the metadata xml files. When empty they are fetched from the inbox with METADATA_DOWNLOAD_COMMAND")
+}
+
+// Check validates the metadata in dir, or fetches it from the inbox first if dir is empty. It
+// fails when the metadata can not be validated against the XSDs.
+func Check(cfg *config.Config, dir string, inbox []models.FileInfo) (*Result, error) {
+	if err := checkSchemaTools(cfg.MetadataXsdDir); err != nil {
+		return nil, err
+	}
+
+	if dir == "" {
+		if cfg.MetadataDownloadCommand == "" {
+			return nil, fmt.Errorf("either a metadata directory or METADATA_DOWNLOAD_COMMAND is requiered")
+		}
+
+		tmp, err := os.MkdirTemp("", "submitter-metadata-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp) //nolint:errcheck
+
+		if err := Fetch(&CommandDownloader{Command: cfg.MetadataDownloadCommand}, inbox, cfg.DatasetFolder, tmp); err != nil {
+			return nil, err
+		}
+		dir = tmp
+	}
+
+	v := &Validator{XsdDir: cfg.MetadataXsdDir, DatasetID: cfg.DatasetID, DatasetFolder: cfg.DatasetFolder}
+	return v.Validate(dir, inbox)
+}
+
+// Problem is a single validation issue in a metadata file
+type Problem struct {
+	File    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// Result holds the outcome of validating the metadata of a dataset
+type Result struct {
+	Files    []string
+	Problems []Problem
+}
+
+func (r *Result) add(file string, format string, args ...any) {
+	r.Problems = append(r.Problems, Problem{File: file, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns nil if no problems were found, otherwise an error listing every problem
+func (r *Result) Err() error {
+	if len(r.Problems) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "metadata validation failed with %d problems:", len(r.Problems))
+	for _, p := range r.Problems {
+		fmt.Fprintf(&b, "\n  - %s", p)
+	}
+	return errors.New(b.String())
+}
+
+// Validator checks the BigPicture METADATA folder of a dataset
+type Validator struct {
+	// XsdDir holds the BigPicture XSDs, <name>.xml is validated against the schema in
+	// <name>.xsd or <prefix>.<name>.xsd. Schema validation is skipped when empty, Check
+	// requires it.
+	XsdDir        string
+	DatasetID     string
+	DatasetFolder string
+}
+
+// element is the part of an XML element needed for the cross-reference checks
+type element struct {
+	name  string
+	attrs map[string]string
+	depth int
+}
+
+// Validate validates every .xml file in dir. inbox is the list of files uploaded for the
+// dataset, used to check that every file referenced in the metadata has been uploaded.
+func (v *Validator) Validate(dir string, inbox []models.FileInfo) (*Result, error) {
+	xmlFiles, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(xmlFiles)
+
+	result := &Result{}
+	if len(xmlFiles) == 0 {
+		result.add(dir, "no metadata xml files found")
+		return result, nil
+	}
+
+	uploaded := map[string]bool{}
+	for _, f := range inbox {
+		uploaded[helpers.RelativeToFolder(f.InboxPath, v.DatasetFolder)] = true
+	}
+
+	var foundDataset bool
+	for _, xmlFile := range xmlFiles {
+		name := filepath.Base(xmlFile)
+		result.Files = append(result.Files, name)
+
+		elements, err := parse(xmlFile)
+		if err != nil {
+			result.add(name, "not well-formed XML: %v", err)
+			continue
+		}
+
+		if v.XsdDir != "" {
+			if err := v.validateSchema(xmlFile); err != nil {
+				result.add(name, "%v", err)
+			}
+		}
+
+		for _, e := range elements {
+			switch {
+			case e.name == "DATASET" && e.depth <= 1:
+				foundDataset = true
+				if alias := e.attrs["alias"]; alias != v.DatasetID {
+					result.add(name, "dataset alias %q does not match DATASET_ID %q", alias, v.DatasetID)
+				}
+			case e.name == "FILE" && e.attrs["filename"] != "":
+				filename := strings.TrimPrefix(path.Clean(e.attrs["filename"]), "/")
+				if !uploaded[filename] && !uploaded[filename+".c4gh"] {
+					result.add(name, "referenced file %s has not been uploaded", filename)
+				}
+			}
+		}
+	}
+
+	if !foundDataset {
+		result.add(dir, "no DATASET element found in any metadata file")
+	}
+
+	return result, nil
+}
+
+// checkSchemaTools returns an error if the XSDs or xmllint are missing, skipping the schema
+// validation would let metadata that the archive rejects through
+func checkSchemaTools(xsdDir string) error {
+	if xsdDir == "" {
+		return fmt.Errorf("METADATA_XSD_DIR is required to validate the metadata against the BigPicture XSDs")
+	}
+	if info, err := os.Stat(xsdDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("METADATA_XSD_DIR %s is not a directory", xsdDir)
+	}
+	if _, err := exec.LookPath("xmllint"); err != nil {
+		return fmt.Errorf("xmllint is required to validate the metadata against the BigPicture XSDs: %w", err)
+	}
+
+	return nil
+}
+
+// findSchema returns the schema for <base>.xml in xsdDir, <base>.xsd or <prefix>.<base>.xsd.
+// More than one match is an error rather than picking one of them.
+func findSchema(xsdDir string, base string) (string, error) {
+	schemas, err := filepath.Glob(filepath.Join(xsdDir, "*."+base+".xsd"))
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(xsdDir, base+".xsd")); err == nil {
+		schemas = append(schemas, filepath.Join(xsdDir, base+".xsd"))
+	}
+
+	switch len(schemas) {
+	case 0:
+		return "", fmt.Errorf("no schema found for %s in %s", base, xsdDir)
+	case 1:
+		return schemas[0], nil
+	default:
+		var names []string
+		for _, schema := range schemas {
+			names = append(names, filepath.Base(schema))
+		}
+		return "", fmt.Errorf("more than one schema found for %s in %s: %s", base, xsdDir, strings.Join(names, ", "))
+	}
+}
+
+// validateSchema runs xmllint against the schema for xmlFile
+func (v *Validator) validateSchema(xmlFile string) error {
+	schema, err := findSchema(v.XsdDir, strings.TrimSuffix(filepath.Base(xmlFile), ".xml"))
+	if err != nil {
+		return err
+	}
+
+	xmllint, err := exec.LookPath("xmllint")
+	if err != nil {
+		return fmt.Errorf("xmllint is required for schema validation: %w", err)
+	}
+
+	var stderr bytes.Buffer
+	command := exec.Command(xmllint, "--noout", "--schema", schema, xmlFile)
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("does not validate against %s: %s", filepath.Base(schema), strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+func parse(xmlFile string) ([]element, error) {
+	file, err := os.Open(xmlFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	var elements []element
+	var depth int
+	decoder := xml.NewDecoder(file)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			attrs := map[string]string{}
+			for _, a := range t.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			elements = append(elements, element{name: t.Name.Local, attrs: attrs, depth: depth})
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+
+	return elements, nil
+}
+
+// MetadataFiles returns the uploaded xml files in the METADATA folder of the dataset
+func MetadataFiles(inbox []models.FileInfo, datasetFolder string) []models.FileInfo {
+	var files []models.FileInfo
+	for _, f := range inbox {
+		rel := helpers.RelativeToFolder(f.InboxPath, datasetFolder)
+		if strings.HasPrefix(rel, "METADATA/") && (strings.HasSuffix(rel, ".xml") || strings.HasSuffix(rel, ".xml.c4gh")) {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Downloader fetches a file from the inbox and stores it decrypted at destination
+type Downloader interface {
+	Download(inboxPath string, destination string) error
+}
+
+// CommandDownloader runs a command for each file, the command is split on whitespace and each
+// argument is a template rendered with .InboxPath and .Destination, e.g.
+// "fetch-metadata.sh {{.InboxPath}} {{.Destination}}". A path with spaces stays one argument.
+type CommandDownloader struct {
+	Command string
+}
+
+func (d *CommandDownloader) Download(inboxPath string, destination string) error {
+	fields := splitCommand(d.Command)
+	if len(fields) == 0 {
+		return fmt.Errorf("download command is empty")
+	}
+
+	data := map[string]string{"InboxPath": inboxPath, "Destination": destination}
+	args := make([]string, 0, len(fields))
+	for _, field := range fields {
+		tmpl, err := template.New("command").Option("missingkey=error").Parse(field)
+		if err != nil {
+			return fmt.Errorf("could not parse download command: %w", err)
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return fmt.Errorf("could not render download command: %w", err)
+		}
+		args = append(args, buf.String())
+	}
+
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("download of %s failed: %w: %s", inboxPath, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// splitCommand splits command on whitespace outside of template actions, so that
+// "{{ .InboxPath }}" stays one argument
+func splitCommand(command string) []string {
+	var fields []string
+	var field strings.Builder
+	depth := 0
+	for i := 0; i < len(command); i++ {
+		switch {
+		case strings.HasPrefix(command[i:], "{{"):
+			depth++
+			field.WriteString("{{")
+			i++
+		case strings.HasPrefix(command[i:], "}}") && depth > 0:
+			depth--
+			field.WriteString("}}")
+			i++
+		case depth == 0 && (command[i] == ' ' || command[i] == '\t' || command[i] == '\n'):
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteByte(command[i])
+		}
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// Fetch downloads the metadata files of the dataset to dir, named by their base name
+// without the .c4gh extension
+func Fetch(downloader Downloader, inbox []models.FileInfo, datasetFolder string, dir string) error {
+	files := MetadataFiles(inbox, datasetFolder)
+	if len(files) == 0 {
+		return fmt.Errorf("no metadata files found in %s/METADATA", datasetFolder)
+	}
+
+	for _, f := range files {
+		destination := filepath.Join(dir, strings.TrimSuffix(path.Base(f.InboxPath), ".c4gh"))
+		if err := downloader.Download(f.InboxPath, destination); err != nil {
+			return err
+		}
+		slog.Info("fetched metadata file", "inbox_path", f.InboxPath, "destination", destination)
+	}
+
+	return nil
+}
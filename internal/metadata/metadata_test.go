@@ -0,0 +1,200 @@
+package metadata
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+const datasetXML = `<?xml version="1.0" encoding="UTF-8"?>
+<DATASET_SET>
+  <DATASET alias="aa-Dataset-abc" center_name="NBIS">
+    <TITLE>Test dataset</TITLE>
+  </DATASET>
+</DATASET_SET>
+`
+
+const imageXML = `<?xml version="1.0" encoding="UTF-8"?>
+<IMAGE_SET>
+  <IMAGE alias="image-1">
+    <FILES>
+      <FILE filename="IMAGES/image-1/slide.dcm" checksum_method="SHA256" checksum="abc"/>
+      <FILE filename="IMAGES/image-2/slide.dcm" checksum_method="SHA256" checksum="def"/>
+    </FILES>
+  </IMAGE>
+</IMAGE_SET>
+`
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestValidate(t *testing.T) {
+	inbox := []models.FileInfo{
+		{InboxPath: "/user/DATASET_ABC/METADATA/dataset.xml.c4gh"},
+		{InboxPath: "/user/DATASET_ABC/METADATA/image.xml.c4gh"},
+		{InboxPath: "/user/DATASET_ABC/IMAGES/image-1/slide.dcm.c4gh"},
+	}
+	v := &Validator{DatasetID: "aa-Dataset-abc", DatasetFolder: "DATASET_ABC"}
+
+	t.Run("Missing image and broken xml", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"dataset.xml": datasetXML,
+			"image.xml":   imageXML,
+			"sample.xml":  "<SAMPLE_SET><SAMPLE></SAMPLE_SET>",
+		})
+
+		result, err := v.Validate(dir, inbox)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Problems) != 2 {
+			t.Fatalf("expected 2 problems, got %v", result.Problems)
+		}
+
+		report := result.Err().Error()
+		for _, expected := range []string{"IMAGES/image-2/slide.dcm has not been uploaded", "sample.xml: not well-formed XML"} {
+			if !strings.Contains(report, expected) {
+				t.Errorf("expected %q in report:\n%s", expected, report)
+			}
+		}
+	})
+
+	t.Run("Dataset alias mismatch", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"dataset.xml": strings.Replace(datasetXML, "aa-Dataset-abc", "aa-Dataset-other", 1),
+		})
+
+		result, err := v.Validate(dir, inbox)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Err() == nil || !strings.Contains(result.Err().Error(), "does not match DATASET_ID") {
+			t.Errorf("expected alias mismatch, got %v", result.Problems)
+		}
+	})
+
+	t.Run("Metadata files in inbox", func(t *testing.T) {
+		if got := len(MetadataFiles(inbox, "DATASET_ABC")); got != 2 {
+			t.Errorf("expected 2 metadata files, got %d", got)
+		}
+	})
+}
+
+const datasetXSD = `<?xml version="1.0" encoding="UTF-8"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="DATASET_SET">
+    <xs:complexType>
+      <xs:sequence>
+        <xs:element name="DATASET">
+          <xs:complexType>
+            <xs:sequence>
+              <xs:element name="TITLE" type="xs:string"/>
+            </xs:sequence>
+            <xs:attribute name="alias" type="xs:string" use="required"/>
+            <xs:attribute name="center_name" type="xs:string"/>
+          </xs:complexType>
+        </xs:element>
+      </xs:sequence>
+    </xs:complexType>
+  </xs:element>
+</xs:schema>
+`
+
+func TestValidateSchema(t *testing.T) {
+	if _, err := exec.LookPath("xmllint"); err != nil {
+		t.Skip("xmllint is not installed")
+	}
+
+	xsdDir := writeFiles(t, map[string]string{"BP.dataset.xsd": datasetXSD})
+	v := &Validator{XsdDir: xsdDir, DatasetID: "aa-Dataset-abc", DatasetFolder: "DATASET_ABC"}
+
+	t.Run("Valid", func(t *testing.T) {
+		result, err := v.Validate(writeFiles(t, map[string]string{"dataset.xml": datasetXML}), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := result.Err(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Invalid and without schema", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"dataset.xml": strings.Replace(datasetXML, "<TITLE>Test dataset</TITLE>", "<DESCRIPTION>Test</DESCRIPTION>", 1),
+			"sample.xml":  "<SAMPLE_SET/>",
+		})
+
+		result, err := v.Validate(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := result.Err().Error()
+		for _, expected := range []string{"dataset.xml: does not validate against BP.dataset.xsd", "sample.xml: no schema found for sample"} {
+			if !strings.Contains(report, expected) {
+				t.Errorf("expected %q in report:\n%s", expected, report)
+			}
+		}
+	})
+}
+
+func TestFindSchema(t *testing.T) {
+	xsdDir := writeFiles(t, map[string]string{
+		"BP.biosample.xsd": "",
+		"BP.image.xsd":     "",
+		"image.xsd":        "",
+		"dataset.xsd":      "",
+	})
+
+	if schema, err := findSchema(xsdDir, "dataset"); err != nil || filepath.Base(schema) != "dataset.xsd" {
+		t.Errorf("expected dataset.xsd, got %q, %v", schema, err)
+	}
+	if _, err := findSchema(xsdDir, "sample"); err == nil || !strings.Contains(err.Error(), "no schema found") {
+		t.Errorf("expected BP.biosample.xsd not to match sample, got %v", err)
+	}
+	if _, err := findSchema(xsdDir, "image"); err == nil || !strings.Contains(err.Error(), "more than one schema") {
+		t.Errorf("expected an error for two image schemas, got %v", err)
+	}
+}
+
+func TestCommandDownloader(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "my metadata.xml")
+	if err := os.WriteFile(source, []byte(datasetXML), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	destination := filepath.Join(dir, "copy of dataset.xml")
+	d := &CommandDownloader{Command: "cp {{ .InboxPath }}\t{{.Destination}}"}
+	if err := d.Download(source, destination); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(destination); err != nil || string(data) != datasetXML {
+		t.Errorf("expected the file to be copied to a path with spaces, got %v", err)
+	}
+}
+
+func TestCheckRequiresSchemas(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"dataset.xml": datasetXML})
+	cfg := &config.Config{DatasetID: "aa-Dataset-abc", DatasetFolder: "DATASET_ABC"}
+
+	if _, err := Check(cfg, dir, nil); err == nil || !strings.Contains(err.Error(), "METADATA_XSD_DIR") {
+		t.Errorf("expected an error without METADATA_XSD_DIR, got %v", err)
+	}
+
+	cfg.MetadataXsdDir = filepath.Join(dir, "missing")
+	if _, err := Check(cfg, dir, nil); err == nil {
+		t.Error("expected an error for a METADATA_XSD_DIR that does not exist")
+	}
+}
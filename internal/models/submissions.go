@@ -6,4 +6,5 @@ type FileInfo struct {
 	InboxPath   string `json:"inboxPath"`
 	Status      string `json:"fileStatus"`
 	CreateAt    string `json:"createAt"`
+	Size        int64  `json:"fileSize,omitempty"`
 }
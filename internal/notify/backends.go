@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Slack posts events to a Slack incoming webhook
+type Slack struct {
+	url        string
+	httpClient *http.Client
+}
+
+func (s *Slack) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(map[string]string{"text": event.Text()})
+	if err != nil {
+		return err
+	}
+
+	return send(ctx, s.httpClient, http.MethodPost, s.url, body, nil)
+}
+
+// Matrix sends events as text messages to a Matrix room
+type Matrix struct {
+	homeserver string
+	room       string
+	token      string
+	httpClient *http.Client
+}
+
+func (m *Matrix) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(map[string]string{"msgtype": "m.text", "body": event.Text()})
+	if err != nil {
+		return err
+	}
+
+	txnID := strconv.FormatInt(time.Now().UnixNano(), 10)
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(m.homeserver, "/"), url.PathEscape(m.room), txnID)
+
+	return send(ctx, m.httpClient, http.MethodPut, endpoint, body, map[string]string{"Authorization": "Bearer " + m.token})
+}
+
+// Webhook posts events as JSON. When a secret is set the body is signed with HMAC-SHA256 over
+// "<timestamp>.<body>", sent in X-Submitter-Signature together with X-Submitter-Timestamp.
+type Webhook struct {
+	url        string
+	secret     string
+	httpClient *http.Client
+}
+
+func (w *Webhook) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{"X-Submitter-Event": event.Type}
+	if w.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		headers["X-Submitter-Timestamp"] = timestamp
+		headers["X-Submitter-Signature"] = "sha256=" + Sign(w.secret, timestamp, body)
+	}
+
+	return send(ctx, w.httpClient, http.MethodPost, w.url, body, headers)
+}
+
+// Sign returns the hex encoded signature of a webhook body, receivers can use it to verify
+// X-Submitter-Signature
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func send(ctx context.Context, httpClient *http.Client, method string, endpoint string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	// The endpoint can contain secrets, e.g. for Slack webhooks, so it is left out of errors
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("notification request failed: %w", urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()        //nolint:errcheck
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification request returned %s", resp.Status)
+	}
+
+	return nil
+}
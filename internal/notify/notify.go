@@ -0,0 +1,169 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/report"
+)
+
+// Events that notifiers can subscribe to through EVENTS in NOTIFIERS
+const (
+	EventJobStarted     = "job_started"
+	EventStepCompleted  = "step_completed"
+	EventWaitingStalled = "waiting_stalled"
+	EventJobFinished    = "job_finished"
+	EventJobFailed      = "job_failed"
+)
+
+// Notifier types selectable through TYPE in NOTIFIERS
+const (
+	TypeSlack   = "slack"
+	TypeMatrix  = "matrix"
+	TypeWebhook = "webhook"
+)
+
+// Event is what happened during a job. It is posted as is by the generic webhook and turned
+// into a chat message by the other notifiers.
+type Event struct {
+	Type          string         `json:"event"`
+	Time          time.Time      `json:"time"`
+	DatasetID     string         `json:"dataset_id"`
+	DatasetFolder string         `json:"dataset_folder"`
+	UserID        string         `json:"user_id"`
+	Message       string         `json:"message"`
+	Step          string         `json:"step,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	Problems      []string       `json:"problems,omitempty"`
+	Progress      []string       `json:"progress,omitempty"`
+	Report        *report.Report `json:"report,omitempty"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// NewEvent returns an event of eventType for the dataset in the report
+func NewEvent(eventType string, rep *report.Report, message string) Event {
+	return Event{
+		Type:          eventType,
+		Time:          time.Now().UTC(),
+		DatasetID:     rep.DatasetID,
+		DatasetFolder: rep.DatasetFolder,
+		UserID:        rep.UserID,
+		Message:       message,
+		Progress:      rep.Progress(),
+		Report:        rep,
+	}
+}
+
+// Text is the human readable form of the event used in chat messages
+func (e Event) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[submitter] %s: %s", e.DatasetID, e.Message)
+	if e.Error != "" {
+		fmt.Fprintf(&b, "\nerror: %s", e.Error)
+	}
+	for _, problem := range e.Problems {
+		fmt.Fprintf(&b, "\n- %s", problem)
+	}
+	return b.String()
+}
+
+type subscription struct {
+	name     string
+	notifier Notifier
+	events   []string
+}
+
+// Dispatcher sends events to every notifier subscribed to them
+type Dispatcher struct {
+	subscriptions []subscription
+}
+
+// New creates a dispatcher for the notifiers in NOTIFIERS. ALERT_WEBHOOK_URL is added as a
+// generic webhook subscribed to job_failed.
+func New(cfg *config.Config) (*Dispatcher, error) {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	d := &Dispatcher{}
+
+	for _, n := range cfg.Notifiers {
+		var notifier Notifier
+		switch n.Type {
+		case TypeSlack:
+			notifier = &Slack{url: n.URL, httpClient: httpClient}
+		case TypeMatrix:
+			notifier = &Matrix{homeserver: n.URL, room: n.Room, token: n.Token, httpClient: httpClient}
+		case TypeWebhook:
+			notifier = &Webhook{url: n.URL, secret: n.Secret, httpClient: httpClient}
+		default:
+			return nil, fmt.Errorf("notifier %s has unknown TYPE %q", n.Name, n.Type)
+		}
+
+		events := n.Events
+		if len(events) == 0 {
+			events = []string{EventJobFinished, EventJobFailed}
+		}
+		d.subscriptions = append(d.subscriptions, subscription{name: n.Name, notifier: notifier, events: events})
+	}
+
+	if cfg.AlertWebhookURL != "" {
+		d.subscriptions = append(d.subscriptions, subscription{
+			name:     "ALERT_WEBHOOK_URL",
+			notifier: &Webhook{url: cfg.AlertWebhookURL, httpClient: httpClient},
+			events:   []string{EventJobFailed},
+		})
+	}
+
+	return d, nil
+}
+
+// Delivery is the outcome of sending an event to one notifier or mail recipient
+type Delivery struct {
+	Name string
+	Err  error
+}
+
+// Notify sends the event to all subscribed notifiers, one failing notifier does not stop the
+// event from reaching the others
+func (d *Dispatcher) Notify(ctx context.Context, event Event) error {
+	var errs []error
+	for _, delivery := range d.Deliver(ctx, event) {
+		if delivery.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", delivery.Name, delivery.Err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Deliver sends the event to all subscribed notifiers and returns the outcome per notifier
+func (d *Dispatcher) Deliver(ctx context.Context, event Event) []Delivery {
+	if d == nil {
+		return nil
+	}
+
+	var deliveries []Delivery
+	for _, s := range d.subscriptions {
+		if !slices.Contains(s.events, event.Type) {
+			continue
+		}
+
+		err := s.notifier.Notify(ctx, event)
+		if err != nil {
+			slog.Warn("[notify] could not send event", "notifier", s.name, "event", event.Type, "err", err)
+		} else {
+			slog.Info("[notify] event sent", "notifier", s.name, "event", event.Type)
+		}
+		deliveries = append(deliveries, Delivery{Name: s.name, Err: err})
+	}
+
+	return deliveries
+}
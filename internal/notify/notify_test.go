@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/report"
+)
+
+type recordedRequest struct {
+	method  string
+	path    string
+	headers http.Header
+	body    []byte
+}
+
+func newStandIn(t *testing.T) (*httptest.Server, func() []recordedRequest) {
+	var mu sync.Mutex
+	var requests []recordedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		requests = append(requests, recordedRequest{method: r.Method, path: r.URL.Path, headers: r.Header, body: body})
+		mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/broken") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []recordedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]recordedRequest(nil), requests...)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	server, requests := newStandIn(t)
+
+	cfg := &config.Config{
+		Notifiers: []config.Notifier{
+			{Name: "slack", Type: TypeSlack, URL: server.URL + "/slack", Events: []string{EventJobFinished}},
+			{Name: "matrix", Type: TypeMatrix, URL: server.URL, Room: "!ops:example.org", Token: "secret-token", Events: []string{EventJobFinished}},
+			{Name: "webhook", Type: TypeWebhook, URL: server.URL + "/webhook", Secret: "shared", Events: []string{EventJobFinished, EventJobStarted}},
+		},
+	}
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep := report.New("DATASET_ABC", "aa-Dataset-abc", "user", 2)
+	if err := d.Notify(context.Background(), NewEvent(EventJobStarted, rep, "job started")); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(requests()); got != 1 {
+		t.Fatalf("expected job_started to reach only the webhook, got %d requests", got)
+	}
+
+	if err := d.Notify(context.Background(), NewEvent(EventJobFinished, rep, "dataset submission completed")); err != nil {
+		t.Fatal(err)
+	}
+
+	byPath := map[string]recordedRequest{}
+	for _, r := range requests()[1:] {
+		byPath[r.path] = r
+	}
+
+	t.Run("Slack", func(t *testing.T) {
+		var msg map[string]string
+		if err := json.Unmarshal(byPath["/slack"].body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(msg["text"], "dataset submission completed") {
+			t.Errorf("unexpected slack message: %s", msg["text"])
+		}
+	})
+
+	t.Run("Matrix", func(t *testing.T) {
+		var matrix recordedRequest
+		for path, r := range byPath {
+			if strings.HasPrefix(path, "/_matrix/client/v3/rooms/!ops:example.org/send/m.room.message/") {
+				matrix = r
+			}
+		}
+		if matrix.method != http.MethodPut {
+			t.Fatalf("expected matrix message to be sent with PUT, got %q", matrix.method)
+		}
+		if matrix.headers.Get("Authorization") != "Bearer secret-token" {
+			t.Error("expected matrix access token in Authorization header")
+		}
+	})
+
+	t.Run("Webhook signature", func(t *testing.T) {
+		webhook := byPath["/webhook"]
+		timestamp := webhook.headers.Get("X-Submitter-Timestamp")
+		expected := "sha256=" + Sign("shared", timestamp, webhook.body)
+		if webhook.headers.Get("X-Submitter-Signature") != expected {
+			t.Error("webhook signature does not match body")
+		}
+
+		var event Event
+		if err := json.Unmarshal(webhook.body, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != EventJobFinished || event.DatasetID != "aa-Dataset-abc" {
+			t.Errorf("unexpected webhook event: %+v", event)
+		}
+	})
+}
+
+func TestDispatcherContinuesAfterFailure(t *testing.T) {
+	server, requests := newStandIn(t)
+
+	cfg := &config.Config{
+		Notifiers: []config.Notifier{
+			{Name: "broken", Type: TypeWebhook, URL: server.URL + "/broken", Events: []string{EventJobFailed}},
+			{Name: "working", Type: TypeSlack, URL: server.URL + "/slack", Events: []string{EventJobFailed}},
+		},
+	}
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep := report.New("DATASET_ABC", "aa-Dataset-abc", "user", 2)
+	if err := d.Notify(context.Background(), NewEvent(EventJobFailed, rep, "job failed")); err == nil {
+		t.Error("expected error from broken notifier")
+	}
+	if got := len(requests()); got != 2 {
+		t.Errorf("expected both notifiers to be called, got %d requests", got)
+	}
+}
@@ -0,0 +1,389 @@
+package rems
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/NBISweden/submitter/cmd"
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/history"
+	"github.com/NBISweden/submitter/internal/report"
+	"github.com/spf13/cobra"
+)
+
+var dryRun bool
+var configPath string
+var dataDirectory string
+
+var remsCmd = &cobra.Command{
+	Use:   "rems [flags]",
+	Short: "Register the dataset as a REMS resource and catalogue item",
+	Long:  "Create or update the REMS resource for DATASET_ID and the catalogue item that lets users apply for access to it",
+	Args: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := cmd.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+
+		c, err := New(cfg)
+		if err != nil {
+			return err
+		}
+
+		if dryRun {
+			resource, err := c.findResource(cfg.DatasetID)
+			if err != nil {
+				return err
+			}
+			if resource == nil {
+				slog.Info("resource would be created", "resid", cfg.DatasetID)
+				return nil
+			}
+
+			items, err := c.findCatalogueItems(cfg.DatasetID)
+			if err != nil {
+				return err
+			}
+			slog.Info("resource already exists", "resid", cfg.DatasetID, "id", resource.ID, "catalogue_items", len(items))
+			return nil
+		}
+
+		return history.RecordCommand(dataDirectory, "rems", cfg, func(rep *report.Report) error {
+			step := rep.StartStep("rems")
+			result, err := c.Register(cfg.DatasetID)
+			if err != nil {
+				return err
+			}
+			rep.Rems = &report.Rems{
+				ResourceID:           result.ResourceID,
+				CatalogueItemID:      result.CatalogueItemID,
+				CreatedResource:      result.CreatedResource,
+				CreatedCatalogueItem: result.CreatedCatalogueItem,
+				UpdatedCatalogueItem: result.UpdatedCatalogueItem,
+			}
+			step.Finish(1, nil)
+			slog.Info("registered dataset in REMS", "resid", cfg.DatasetID, "resource_id", result.ResourceID, "catalogue_item_id", result.CatalogueItemID)
+
+			return nil
+		})
+	},
+}
+
+func init() {
+	cmd.AddCommand(remsCmd)
+	remsCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Toggles dry-run mode. Dry run only looks up the existing resource and catalogue items")
+	remsCmd.Flags().StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
+	remsCmd.Flags().StringVar(&dataDirectory, "data-directory", "data", "Path to the data directory holding the history")
+}
+
+// Client talks to the REMS API as the user in REMS_USER_ID
+type Client struct {
+	host         string
+	apiKey       string
+	userID       string
+	organization string
+	workflowID   int
+	formID       int
+	licenseIDs   []int
+	title        string
+	infoURL      string
+	httpClient   *http.Client
+}
+
+// Result describes the REMS resource and catalogue item of a dataset
+type Result struct {
+	ResourceID           int
+	CatalogueItemID      int
+	CreatedResource      bool
+	CreatedCatalogueItem bool
+	UpdatedCatalogueItem bool
+}
+
+type organization struct {
+	ID string `json:"organization/id"`
+}
+
+type license struct {
+	ID int `json:"id"`
+}
+
+type localization struct {
+	Title   string `json:"title"`
+	InfoURL string `json:"infourl,omitempty"`
+}
+
+type resource struct {
+	ID       int       `json:"id"`
+	Resid    string    `json:"resid"`
+	Licenses []license `json:"licenses"`
+}
+
+type catalogueItem struct {
+	ID            int                     `json:"id"`
+	ResourceID    int                     `json:"resource-id"`
+	Resid         string                  `json:"resid"`
+	Enabled       bool                    `json:"enabled"`
+	Archived      bool                    `json:"archived"`
+	Localizations map[string]localization `json:"localizations"`
+}
+
+type response struct {
+	Success bool `json:"success"`
+	ID      int  `json:"id"`
+}
+
+func New(cfg *config.Config) (*Client, error) {
+	switch {
+	case cfg.RemsHost == "":
+		return nil, fmt.Errorf("REMS_HOST requiered")
+	case cfg.RemsApiKey == "" || cfg.RemsUserID == "":
+		return nil, fmt.Errorf("REMS_API_KEY and REMS_USER_ID requiered")
+	case cfg.RemsOrganization == "":
+		return nil, fmt.Errorf("REMS_ORGANIZATION requiered")
+	case cfg.RemsWorkflowID == 0:
+		return nil, fmt.Errorf("REMS_WORKFLOW_ID requiered")
+	}
+
+	title := cfg.RemsTitle
+	if title == "" {
+		title = cfg.DatasetID
+	}
+
+	return &Client{
+		host:         strings.TrimSuffix(cfg.RemsHost, "/"),
+		apiKey:       cfg.RemsApiKey,
+		userID:       cfg.RemsUserID,
+		organization: cfg.RemsOrganization,
+		workflowID:   cfg.RemsWorkflowID,
+		formID:       cfg.RemsFormID,
+		licenseIDs:   cfg.RemsLicenseIDs,
+		title:        title,
+		infoURL:      cfg.RemsInfoURL,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Register makes sure there is a resource with resid and an enabled catalogue item for it.
+// Existing resources are reused, existing catalogue items get the configured title and info url.
+func (c *Client) Register(resid string) (*Result, error) {
+	result := &Result{}
+
+	res, err := c.findResource(resid)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		res, err = c.createResource(resid)
+		if err != nil {
+			return nil, err
+		}
+		result.CreatedResource = true
+		slog.Info("created REMS resource", "resid", resid, "id", res.ID)
+	} else if missing := c.missingLicenses(res); len(missing) > 0 {
+		// Licenses of a resource can not be changed in REMS, they have to be set on creation
+		slog.Warn("existing REMS resource is missing licenses", "resid", resid, "id", res.ID, "licenses", missing)
+	}
+	result.ResourceID = res.ID
+
+	items, err := c.findCatalogueItems(resid)
+	if err != nil {
+		return nil, err
+	}
+
+	var item *catalogueItem
+	for i := range items {
+		if items[i].ResourceID == res.ID && !items[i].Archived {
+			item = &items[i]
+			break
+		}
+	}
+
+	if item == nil {
+		id, err := c.createCatalogueItem(res.ID)
+		if err != nil {
+			return nil, err
+		}
+		result.CatalogueItemID = id
+		result.CreatedCatalogueItem = true
+		slog.Info("created REMS catalogue item", "resid", resid, "id", id)
+		return result, nil
+	}
+	result.CatalogueItemID = item.ID
+
+	if item.Localizations["en"] != c.localization() {
+		if err := c.editCatalogueItem(item.ID); err != nil {
+			return nil, err
+		}
+		result.UpdatedCatalogueItem = true
+		slog.Info("updated REMS catalogue item", "resid", resid, "id", item.ID)
+	}
+
+	if !item.Enabled {
+		if err := c.do(http.MethodPut, "/api/catalogue-items/enabled", map[string]any{"id": item.ID, "enabled": true}, nil); err != nil {
+			return nil, fmt.Errorf("could not enable catalogue item %d: %w", item.ID, err)
+		}
+		result.UpdatedCatalogueItem = true
+		slog.Info("enabled REMS catalogue item", "resid", resid, "id", item.ID)
+	}
+
+	return result, nil
+}
+
+func (c *Client) findResource(resid string) (*resource, error) {
+	var resources []resource
+	query := url.Values{"resid": {resid}, "disabled": {"true"}, "archived": {"true"}}
+	if err := c.do(http.MethodGet, "/api/resources?"+query.Encode(), nil, &resources); err != nil {
+		return nil, fmt.Errorf("could not look up resource %s: %w", resid, err)
+	}
+
+	for i := range resources {
+		if resources[i].Resid == resid {
+			return &resources[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *Client) createResource(resid string) (*resource, error) {
+	body := map[string]any{
+		"resid":        resid,
+		"organization": organization{ID: c.organization},
+		"licenses":     append([]int{}, c.licenseIDs...),
+	}
+
+	var resp response
+	if err := c.do(http.MethodPost, "/api/resources/create", body, &resp); err != nil {
+		return nil, fmt.Errorf("could not create resource %s: %w", resid, err)
+	}
+
+	return &resource{ID: resp.ID, Resid: resid}, nil
+}
+
+func (c *Client) missingLicenses(res *resource) []int {
+	var missing []int
+	for _, id := range c.licenseIDs {
+		found := false
+		for _, l := range res.Licenses {
+			if l.ID == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+func (c *Client) findCatalogueItems(resid string) ([]catalogueItem, error) {
+	var items []catalogueItem
+	query := url.Values{"resource": {resid}, "disabled": {"true"}, "archived": {"true"}}
+	if err := c.do(http.MethodGet, "/api/catalogue-items?"+query.Encode(), nil, &items); err != nil {
+		return nil, fmt.Errorf("could not look up catalogue items for %s: %w", resid, err)
+	}
+
+	return items, nil
+}
+
+func (c *Client) createCatalogueItem(resourceID int) (int, error) {
+	body := map[string]any{
+		"resid":         resourceID,
+		"wfid":          c.workflowID,
+		"organization":  organization{ID: c.organization},
+		"localizations": map[string]localization{"en": c.localization()},
+		"enabled":       true,
+	}
+	if c.formID != 0 {
+		body["form"] = c.formID
+	}
+
+	var resp response
+	if err := c.do(http.MethodPost, "/api/catalogue-items/create", body, &resp); err != nil {
+		return 0, fmt.Errorf("could not create catalogue item: %w", err)
+	}
+
+	return resp.ID, nil
+}
+
+func (c *Client) editCatalogueItem(id int) error {
+	body := map[string]any{
+		"id":            id,
+		"localizations": map[string]localization{"en": c.localization()},
+	}
+
+	if err := c.do(http.MethodPut, "/api/catalogue-items/edit", body, nil); err != nil {
+		return fmt.Errorf("could not update catalogue item %d: %w", id, err)
+	}
+
+	return nil
+}
+
+func (c *Client) localization() localization {
+	return localization{Title: c.title, InfoURL: c.infoURL}
+}
+
+// do sends a request to the REMS API and decodes the response into out. Write requests are
+// checked for "success": true since REMS reports some errors with a 200 response.
+func (c *Client) do(method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, c.host+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-rems-api-key", c.apiKey)
+	req.Header.Set("x-rems-user-id", c.userID)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(content)))
+	}
+
+	if method != http.MethodGet {
+		var status response
+		if err := json.Unmarshal(content, &status); err != nil {
+			return fmt.Errorf("could not decode response: %w", err)
+		}
+		if !status.Success {
+			return errors.New(strings.TrimSpace(string(content)))
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(content, out)
+}
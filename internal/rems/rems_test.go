@@ -0,0 +1,165 @@
+package rems
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/config"
+)
+
+// standIn is a minimal in-memory REMS that serves the endpoints used by the client
+type standIn struct {
+	mu        sync.Mutex
+	resources []resource
+	items     []catalogueItem
+	writes    int
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("x-rems-api-key") != "api-key" || r.Header.Get("x-rems-user-id") != "owner" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var body map[string]any
+	if r.Method != http.MethodGet {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.writes++
+	}
+
+	var out any
+	switch r.Method + " " + r.URL.Path {
+	case "GET /api/resources":
+		found := []resource{}
+		for _, res := range s.resources {
+			if res.Resid == r.URL.Query().Get("resid") {
+				found = append(found, res)
+			}
+		}
+		out = found
+	case "POST /api/resources/create":
+		res := resource{ID: len(s.resources) + 1, Resid: body["resid"].(string)}
+		for _, id := range body["licenses"].([]any) {
+			res.Licenses = append(res.Licenses, license{ID: int(id.(float64))})
+		}
+		s.resources = append(s.resources, res)
+		out = response{Success: true, ID: res.ID}
+	case "GET /api/catalogue-items":
+		found := []catalogueItem{}
+		for _, item := range s.items {
+			if item.Resid == r.URL.Query().Get("resource") {
+				found = append(found, item)
+			}
+		}
+		out = found
+	case "POST /api/catalogue-items/create":
+		resourceID := int(body["resid"].(float64))
+		item := catalogueItem{ID: 100 + len(s.items), ResourceID: resourceID, Resid: s.resources[resourceID-1].Resid, Enabled: true}
+		item.Localizations = decodeLocalizations(body["localizations"])
+		s.items = append(s.items, item)
+		out = response{Success: true, ID: item.ID}
+	case "PUT /api/catalogue-items/edit":
+		for i := range s.items {
+			if s.items[i].ID == int(body["id"].(float64)) {
+				s.items[i].Localizations = decodeLocalizations(body["localizations"])
+			}
+		}
+		out = response{Success: true}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out) //nolint:errcheck
+}
+
+func decodeLocalizations(v any) map[string]localization {
+	content, _ := json.Marshal(v)
+	var localizations map[string]localization
+	json.Unmarshal(content, &localizations) //nolint:errcheck
+	return localizations
+}
+
+func TestRegister(t *testing.T) {
+	rems := &standIn{}
+	server := httptest.NewServer(rems)
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{
+		DatasetID:        "aa-Dataset-abc",
+		RemsHost:         server.URL,
+		RemsApiKey:       "api-key",
+		RemsUserID:       "owner",
+		RemsOrganization: "nbis",
+		RemsWorkflowID:   1,
+		RemsLicenseIDs:   []int{7},
+	}
+
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		result, err := c.Register(cfg.DatasetID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.CreatedResource || !result.CreatedCatalogueItem {
+			t.Errorf("expected resource and catalogue item to be created, got %+v", result)
+		}
+		if len(rems.resources[0].Licenses) != 1 || rems.items[0].Localizations["en"].Title != cfg.DatasetID {
+			t.Errorf("unexpected state in REMS: %+v %+v", rems.resources, rems.items)
+		}
+	})
+
+	t.Run("Already registered", func(t *testing.T) {
+		writes := rems.writes
+		result, err := c.Register(cfg.DatasetID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.CreatedResource || result.CreatedCatalogueItem || result.UpdatedCatalogueItem || rems.writes != writes {
+			t.Errorf("expected nothing to change, got %+v", result)
+		}
+	})
+
+	t.Run("Update title", func(t *testing.T) {
+		updated := *cfg
+		updated.RemsTitle = "A test dataset"
+		c, err := New(&updated)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := c.Register(cfg.DatasetID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.UpdatedCatalogueItem || len(rems.items) != 1 || rems.items[0].Localizations["en"].Title != "A test dataset" {
+			t.Errorf("expected the catalogue item to be updated, got %+v %+v", result, rems.items)
+		}
+	})
+
+	t.Run("Wrong api key", func(t *testing.T) {
+		wrong := *cfg
+		wrong.RemsApiKey = "wrong"
+		c, err := New(&wrong)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Register(cfg.DatasetID); err == nil {
+			t.Error("expected error with wrong api key")
+		}
+	})
+}
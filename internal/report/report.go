@@ -0,0 +1,203 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Report records the progress of a job so that it can be inspected afterwards and included
+// in notifications when the job fails
+type Report struct {
+	DatasetFolder string          `json:"dataset_folder"`
+	DatasetID     string          `json:"dataset_id"`
+	UserID        string          `json:"user_id"`
+	ExpectedFiles int             `json:"expected_files"`
+	StartedAt     time.Time       `json:"started_at"`
+	FinishedAt    time.Time       `json:"finished_at,omitzero"`
+	Status        string          `json:"status"`
+	Retries       int             `json:"retries"`
+	Steps         []*Step         `json:"steps"`
+	Notifications []*Notification `json:"notifications,omitempty"`
+	Rems          *Rems           `json:"rems,omitempty"`
+	Membership    *Membership     `json:"membership,omitempty"`
+	Failure       *Failure        `json:"failure,omitempty"`
+
+	retries func() int
+}
+
+type Step struct {
+	Name       string    `json:"name"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	Status     string    `json:"status"`
+	Count      int       `json:"count"`
+	Retries    int       `json:"retries,omitempty"`
+	Problems   []string  `json:"problems,omitempty"`
+	Error      string    `json:"error,omitempty"`
+
+	retries   func() int
+	retriesAt int
+}
+
+// Notification records a notification sent through a channel, either mail or a notifier
+type Notification struct {
+	Channel   string    `json:"channel"`
+	Recipient string    `json:"recipient"`
+	Event     string    `json:"event"`
+	SentAt    time.Time `json:"sent_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Rems records the REMS resource and catalogue item registered for the dataset
+type Rems struct {
+	ResourceID           int  `json:"resource_id"`
+	CatalogueItemID      int  `json:"catalogue_item_id"`
+	CreatedResource      bool `json:"created_resource"`
+	CreatedCatalogueItem bool `json:"created_catalogue_item"`
+	UpdatedCatalogueItem bool `json:"updated_catalogue_item"`
+}
+
+// Membership records how the dataset changed when files were appended to an existing dataset
+type Membership struct {
+	Before int      `json:"before"`
+	Added  []string `json:"added"`
+	After  int      `json:"after"`
+}
+
+type Failure struct {
+	Step     string   `json:"step"`
+	Error    string   `json:"error"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+func New(datasetFolder string, datasetID string, userID string, expectedFiles int) *Report {
+	return &Report{
+		DatasetFolder: datasetFolder,
+		DatasetID:     datasetID,
+		UserID:        userID,
+		ExpectedFiles: expectedFiles,
+		StartedAt:     time.Now().UTC(),
+		Status:        StatusRunning,
+	}
+}
+
+// CountRetries makes the report record the retried API requests of every step, read from
+// the running total returned by retries
+func (r *Report) CountRetries(retries func() int) {
+	r.retries = retries
+}
+
+// StartStep adds a new running step to the report
+func (r *Report) StartStep(name string) *Step {
+	step := &Step{Name: name, StartedAt: time.Now().UTC(), Status: StatusRunning}
+	if r.retries != nil {
+		step.retries = r.retries
+		step.retriesAt = r.retries()
+	}
+	r.Steps = append(r.Steps, step)
+	return step
+}
+
+// CurrentStep returns the step that was started last, or nil if no step has been started
+func (r *Report) CurrentStep() *Step {
+	if len(r.Steps) == 0 {
+		return nil
+	}
+	return r.Steps[len(r.Steps)-1]
+}
+
+// Finish marks the report as succeeded, or as failed if err is not nil. A failure is
+// attributed to the step that was started last, including checks made after it finished.
+func (r *Report) Finish(err error) {
+	r.FinishedAt = time.Now().UTC()
+	if r.retries != nil {
+		r.Retries = r.retries()
+	}
+	if err == nil {
+		r.Status = StatusSucceeded
+		return
+	}
+
+	r.Status = StatusFailed
+	r.Failure = &Failure{Step: "setup", Error: err.Error()}
+	if step := r.CurrentStep(); step != nil {
+		step.Finish(step.Count, err)
+		r.Failure.Step = step.Name
+		r.Failure.Problems = step.Problems
+	}
+}
+
+// AddNotification records the outcome of sending event to recipient through channel
+func (r *Report) AddNotification(channel string, recipient string, event string, err error) {
+	n := &Notification{Channel: channel, Recipient: recipient, Event: event, SentAt: time.Now().UTC()}
+	if err != nil {
+		n.Error = err.Error()
+	}
+	r.Notifications = append(r.Notifications, n)
+}
+
+// Progress summarises the finished steps, e.g. "ingest: 10 (succeeded)"
+func (r *Report) Progress() []string {
+	var progress []string
+	for _, step := range r.Steps {
+		progress = append(progress, fmt.Sprintf("%s: %d (%s)", step.Name, step.Count, step.Status))
+	}
+	return progress
+}
+
+// Write stores the report as JSON at path
+func (r *Report) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, data, 0640); err != nil {
+		return err
+	}
+	slog.Info("wrote job report", "path", path)
+
+	return nil
+}
+
+// Finish marks the step as succeeded with count processed items, or as failed if err is not nil
+func (s *Step) Finish(count int, err error) {
+	if s == nil {
+		return
+	}
+
+	s.FinishedAt = time.Now().UTC()
+	s.Count = count
+	if s.retries != nil {
+		s.Retries = s.retries() - s.retriesAt
+	}
+	s.Status = StatusSucceeded
+	if err != nil {
+		s.Status = StatusFailed
+		s.Error = err.Error()
+	}
+}
+
+// AddProblem records an issue with a single file. It is safe to call on a nil step, which
+// is what the standalone commands use since they do not keep a report.
+func (s *Step) AddProblem(format string, args ...any) {
+	if s == nil {
+		return
+	}
+	s.Problems = append(s.Problems, strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,409 @@
+package simulate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NBISweden/submitter/cmd"
+	"github.com/NBISweden/submitter/helpers"
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/database"
+	"github.com/NBISweden/submitter/internal/dataset"
+	"github.com/NBISweden/submitter/internal/fakesda"
+	"github.com/NBISweden/submitter/internal/job"
+	"github.com/NBISweden/submitter/internal/mail"
+	"github.com/NBISweden/submitter/internal/models"
+	"github.com/NBISweden/submitter/internal/report"
+	"github.com/NBISweden/submitter/internal/source"
+	"github.com/spf13/cobra"
+)
+
+var configPath string
+var manifestPath string
+var outputDir string
+var verifyAfter int
+var latency time.Duration
+var failures []string
+var jobOptions job.Options
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate <expectedFiles>",
+	Short: "Rehearse a job against a fake SDA API",
+	Long:  "Seed a fake SDA API with the files of the dataset folder, read from the database (or the api with --source api) or from a --manifest, run the job pipeline against it and report timings, requests and what the job would produce. Nothing is sent to the SDA API, REMS, chat or mail recipients.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("simulate must be supplied the expected number of files as argument")
+		}
+
+		var err error
+		jobOptions.ExpectedFiles, err = strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("could not interpert expected number of files %w", err)
+		}
+		return jobOptions.Validate()
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := cmd.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+
+		var injected []fakesda.Failure
+		for _, f := range failures {
+			failure, err := ParseFailure(f)
+			if err != nil {
+				return err
+			}
+			injected = append(injected, failure)
+		}
+
+		files, err := loadFiles(cfg)
+		if err != nil {
+			return err
+		}
+
+		jobOptions.Source = cmd.SourceKind(source.KindDB)
+		summary, err := Run(cfg, files, Options{
+			Job:         jobOptions,
+			OutputDir:   outputDir,
+			VerifyAfter: verifyAfter,
+			Latency:     latency,
+			Failures:    injected,
+		})
+		if err != nil {
+			return err
+		}
+		summary.Print()
+
+		if summary.Status != report.StatusSucceeded {
+			return fmt.Errorf("simulated job failed: %s", summary.Error)
+		}
+		return nil
+	},
+}
+
+func init() {
+	cmd.AddCommand(simulateCmd)
+	simulateCmd.Flags().StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
+	simulateCmd.Flags().StringVar(&manifestPath, "manifest", "", "File with one inbox path per line, optionally followed by a tab and the size in bytes, to seed the fake api with instead of the listed files")
+	simulateCmd.Flags().StringVar(&outputDir, "output", "simulation", "Empty directory to write the report, accession ids, chunk state and rendered mails of the simulated job to")
+	simulateCmd.Flags().IntVar(&verifyAfter, "verify-after", 2, "Number of file listings an ingested file stays submitted before the fake api verifies it")
+	simulateCmd.Flags().DurationVar(&latency, "latency", 0, "Latency added to every response of the fake api")
+	simulateCmd.Flags().StringArrayVar(&failures, "fail", nil, `Inject a failure as "<METHOD> <path prefix> <status> [times]", e.g. "POST dataset/create 503 2". Can be repeated`)
+	simulateCmd.Flags().BoolVar(&jobOptions.Lint, "lint", false, "Check the dataset folder structure before ingestion, as job --lint")
+	simulateCmd.Flags().BoolVar(&jobOptions.ValidateMetadata, "validate-metadata", false, "Validate the BigPicture metadata before ingestion, as job --validate-metadata")
+	simulateCmd.Flags().StringVar(&jobOptions.MetadataDir, "metadata-dir", "", "Local directory with the metadata xml files, as job --metadata-dir")
+	simulateCmd.Flags().BoolVar(&jobOptions.Artifacts, "artifacts", false, "Generate dataset.txt, policy.txt and rems.txt before the mails, as job --artifacts (requires --notify)")
+	simulateCmd.Flags().BoolVar(&jobOptions.Release, "release", false, "Release the dataset in the fake api as the final step, as job --release")
+	simulateCmd.Flags().BoolVar(&jobOptions.Notify, "notify", false, "Verify the dataset, write the stable ids file and write the job_finished mails to the output directory, as job --notify")
+}
+
+// Options of a simulation. Job selects the steps of the simulated job, its data directory and
+// backend are set by Run.
+type Options struct {
+	Job         job.Options
+	OutputDir   string
+	VerifyAfter int
+	Latency     time.Duration
+	Failures    []fakesda.Failure
+}
+
+// Summary is what the simulated job did and what it would have produced
+type Summary struct {
+	Status       string           `json:"status"`
+	Error        string           `json:"error,omitempty"`
+	Duration     string           `json:"duration"`
+	Steps        []StepTiming     `json:"steps"`
+	Requests     map[string]int   `json:"requests"`
+	Retries      int              `json:"retries"`
+	AccessionIDs int              `json:"accession_ids"`
+	Files        map[string]int   `json:"files"`
+	Chunks       []*dataset.Chunk `json:"chunks,omitempty"`
+	Outputs      []string         `json:"outputs"`
+	Mails        []string         `json:"mails,omitempty"`
+	Problems     []string         `json:"problems,omitempty"`
+}
+
+type StepTiming struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Count    int    `json:"count"`
+	Retries  int    `json:"retries,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Run seeds a fake SDA API with files and runs the job pipeline against it with a copy of
+// cfg. The steps that read the database get an in-memory repository that follows the fake.
+// Everything the job produces is written to opts.OutputDir.
+func Run(cfg *config.Config, files []models.FileInfo, opts Options) (*Summary, error) {
+	if err := prepareOutputDir(opts.OutputDir); err != nil {
+		return nil, err
+	}
+
+	db := database.NewMemory()
+	sda := fakesda.NewServer(fakesda.Options{VerifyAfter: opts.VerifyAfter, Latency: opts.Latency, DB: db})
+	defer sda.Close()
+	for _, f := range files {
+		sda.AddFile(cfg.UserID, f)
+	}
+	for _, f := range opts.Failures {
+		sda.Fail(f)
+	}
+	slog.Info("simulating job", "files", len(files), "expected_files", opts.Job.ExpectedFiles, "output", opts.OutputDir)
+
+	jobOpts := opts.Job
+	jobOpts.DataDirectory = opts.OutputDir
+	jobOpts.Command = "simulate"
+	jobOpts.DB = db
+	if jobOpts.Source == "" {
+		jobOpts.Source = source.KindDB
+	}
+	rep, err := job.Run(simulationConfig(cfg, sda.URL, opts.OutputDir), jobOpts)
+	if rep == nil {
+		return nil, err
+	}
+
+	summary := newSummary(rep, sda, cfg.UserID)
+	if state, err := dataset.ReadChunkState(helpers.GetChunkStatePath(opts.OutputDir, cfg.DatasetFolder)); err == nil {
+		summary.Chunks = state.Chunks
+	}
+
+	summary.Outputs, summary.Mails = outputs(opts.OutputDir)
+	if err := writeSummary(filepath.Join(opts.OutputDir, "simulation.json"), summary); err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// simulationConfig points the job at the fake api and makes sure that nothing is sent to the
+// real services: mails are written to the output directory and notifiers are disabled
+func simulationConfig(cfg *config.Config, apiHost string, outputDir string) *config.Config {
+	c := *cfg
+	c.ClientApiHost = apiHost
+	c.ClientAccessToken = "simulation"
+	c.ClientCert = ""
+	c.ClientKey = ""
+	c.ClientProxy = ""
+	c.ClientCassetteMode = ""
+	c.AccessionDelay = 0
+	c.PollRate = 0
+	c.Notifiers = nil
+	c.AlertWebhookURL = ""
+	c.MailTransport = mail.TransportFile
+	c.MailOutputDir = filepath.Join(outputDir, "mail")
+	return &c
+}
+
+func prepareOutputDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("output directory %s is not empty", dir)
+	}
+
+	return os.MkdirAll(filepath.Join(dir, "mail"), 0750)
+}
+
+func newSummary(rep *report.Report, sda *fakesda.Server, userID string) *Summary {
+	summary := &Summary{
+		Status:   rep.Status,
+		Duration: rep.FinishedAt.Sub(rep.StartedAt).Round(time.Millisecond).String(),
+		Requests: sda.Requests(),
+		Retries:  rep.Retries,
+		Files:    map[string]int{},
+	}
+	if rep.Failure != nil {
+		summary.Error = fmt.Sprintf("%s: %s", rep.Failure.Step, rep.Failure.Error)
+	}
+
+	for _, step := range rep.Steps {
+		for _, problem := range step.Problems {
+			summary.Problems = append(summary.Problems, fmt.Sprintf("%s: %s", step.Name, problem))
+		}
+		summary.Steps = append(summary.Steps, StepTiming{
+			Name:     step.Name,
+			Status:   step.Status,
+			Count:    step.Count,
+			Retries:  step.Retries,
+			Duration: step.FinishedAt.Sub(step.StartedAt).Round(time.Millisecond).String(),
+		})
+	}
+
+	for _, f := range sda.Files(userID) {
+		summary.Files[f.Status]++
+		if f.AccessionID != "" {
+			summary.AccessionIDs++
+		}
+	}
+
+	return summary
+}
+
+func outputs(dir string) ([]string, []string) {
+	var files, mails []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error { //nolint:errcheck
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if filepath.Ext(path) == ".eml" {
+			mails = append(mails, path)
+		} else {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, mails
+}
+
+func writeSummary(path string, summary *Summary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return helpers.WriteFileAtomic(path, data, 0640)
+}
+
+// Print writes a readable summary to stdout
+func (s *Summary) Print() {
+	fmt.Printf("simulated job %s in %s\n", s.Status, s.Duration)
+	if s.Error != "" {
+		fmt.Printf("  error: %s\n", s.Error)
+	}
+
+	fmt.Println("steps:")
+	for _, step := range s.Steps {
+		fmt.Printf("  %-15s %-10s %6d files %4d retries %s\n", step.Name, step.Status, step.Count, step.Retries, step.Duration)
+	}
+
+	fmt.Println("requests:")
+	routes := make([]string, 0, len(s.Requests))
+	for route := range s.Requests {
+		routes = append(routes, route)
+	}
+	slices.Sort(routes)
+	for _, route := range routes {
+		fmt.Printf("  %-35s %d\n", route, s.Requests[route])
+	}
+
+	fmt.Printf("accession ids: %d, retries: %d\n", s.AccessionIDs, s.Retries)
+	for _, chunk := range s.Chunks {
+		fmt.Printf("  dataset chunk %d: %d accession ids, %s after %d attempts\n", chunk.Index, len(chunk.AccessionIDs), chunk.Status, chunk.Attempts)
+	}
+	for _, path := range s.Outputs {
+		fmt.Printf("  wrote %s\n", path)
+	}
+	for _, path := range s.Mails {
+		fmt.Printf("  mail %s\n", path)
+	}
+	for _, problem := range s.Problems {
+		fmt.Printf("  problem: %s\n", problem)
+	}
+}
+
+// ParseFailure parses a failure given as "<METHOD> <path prefix> <status> [times]"
+func ParseFailure(s string) (fakesda.Failure, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 && len(fields) != 4 {
+		return fakesda.Failure{}, fmt.Errorf("invalid failure %q, use \"<METHOD> <path prefix> <status> [times]\"", s)
+	}
+
+	failure := fakesda.Failure{Method: strings.ToUpper(fields[0]), Path: strings.TrimPrefix(fields[1], "/"), Times: 1}
+	status, err := strconv.Atoi(fields[2])
+	if err != nil || http.StatusText(status) == "" {
+		return fakesda.Failure{}, fmt.Errorf("invalid status in failure %q", s)
+	}
+	failure.Status = status
+
+	if len(fields) == 4 {
+		if failure.Times, err = strconv.Atoi(fields[3]); err != nil || failure.Times < 1 {
+			return fakesda.Failure{}, fmt.Errorf("invalid number of times in failure %q", s)
+		}
+	}
+	return failure, nil
+}
+
+// loadFiles reads the files to seed the fake api with from the manifest or the file source.
+// The files are seeded as uploaded with their inbox paths starting at the dataset folder, as the
+// job asks for them.
+func loadFiles(cfg *config.Config) ([]models.FileInfo, error) {
+	var files []models.FileInfo
+	var err error
+	if manifestPath != "" {
+		files, err = ReadManifest(manifestPath)
+	} else {
+		files, err = listFiles(cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range files {
+		files[i] = models.FileInfo{InboxPath: inboxPath(f.InboxPath, cfg.DatasetFolder), Size: f.Size, Status: fakesda.StatusUploaded}
+	}
+	return files, nil
+}
+
+func listFiles(cfg *config.Config) ([]models.FileInfo, error) {
+	src, closeSource, err := source.Open(cmd.SourceKind(source.KindDB), cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSource()
+
+	return source.Files(src)
+}
+
+// inboxPath strips a leading slash and anything before the dataset folder, e.g. the user
+// directory in "/user/DATASET_ABC/a.c4gh", so that the path starts with the dataset folder
+func inboxPath(path string, datasetFolder string) string {
+	path = strings.TrimPrefix(path, "/")
+	if datasetFolder == "" || strings.HasPrefix(path, datasetFolder+"/") {
+		return path
+	}
+	if _, rest, found := strings.Cut(path, "/"+datasetFolder+"/"); found {
+		return datasetFolder + "/" + rest
+	}
+	return path
+}
+
+// ReadManifest reads one inbox path per line, optionally followed by a tab and the size in
+// bytes. Empty lines and lines starting with # are skipped.
+func ReadManifest(path string) ([]models.FileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	var files []models.FileInfo
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		inbox, size, found := strings.Cut(text, "\t")
+		f := models.FileInfo{InboxPath: strings.TrimSpace(inbox), Status: fakesda.StatusUploaded}
+		if found {
+			if f.Size, err = strconv.ParseInt(strings.TrimSpace(size), 10, 64); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid size %q", path, line, size)
+			}
+		}
+		files = append(files, f)
+	}
+
+	return files, scanner.Err()
+}
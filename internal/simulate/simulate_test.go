@@ -0,0 +1,110 @@
+package simulate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NBISweden/submitter/helpers"
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/fakesda"
+	"github.com/NBISweden/submitter/internal/history"
+	"github.com/NBISweden/submitter/internal/job"
+	"github.com/NBISweden/submitter/internal/report"
+)
+
+func TestSimulate(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "manifest.txt")
+	content := "# files of DATASET_ABC\nDATASET_ABC/IMAGES/a.c4gh\t1024\nDATASET_ABC/IMAGES/b.c4gh\t2048\n\nDATASET_ABC/PRIVATE/c.c4gh\n"
+	if err := os.WriteFile(manifest, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ReadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[1].Size != 2048 {
+		t.Fatalf("expected 3 files from the manifest, got %v", files)
+	}
+
+	failure, err := ParseFailure("POST dataset/create 503 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		DatasetFolder:          "DATASET_ABC",
+		DatasetID:              "aa-Dataset-abc",
+		UserID:                 "testuser",
+		Timeout:                1,
+		DatasetChunkSize:       1,
+		DatasetChunkRetries:    1,
+		ClientRetryMaxAttempts: 1,
+		MailAddress:            "submitter@example.com",
+		MailUploader:           "jane@example.com",
+		ArtifactsParameters:    map[string]string{"title": "Example dataset", "policy_id": "aa-Policy-abc", "organization": "Example organization"},
+		MailRecipients: []config.Recipient{
+			{Name: "Submitter", To: []string{"{{.UploaderEmail}}"}, Template: "notify-submitter.html", Subject: "Done", Attachments: []string{"{{.DatasetFolder}}-stableIDs.txt"}, Events: []string{"job_finished"}},
+		},
+	}
+	output := filepath.Join(dir, "simulation")
+	steps := job.Options{ExpectedFiles: 2, Notify: true, Artifacts: true, Release: true}
+	summary, err := Run(cfg, files, Options{Job: steps, OutputDir: output, Failures: []fakesda.Failure{failure}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Status != report.StatusSucceeded || summary.AccessionIDs != 2 {
+		t.Fatalf("expected the simulated job to accession 2 files, got %+v", summary)
+	}
+	if summary.Requests["POST /dataset/create"] != 3 || len(summary.Chunks) != 2 || summary.Chunks[0].Attempts != 2 {
+		t.Errorf("expected the failing chunk to be resent, got %v and %+v", summary.Requests, summary.Chunks)
+	}
+	if len(summary.Mails) != 1 || len(summary.Problems) != 0 {
+		t.Errorf("expected 1 rendered mail, got %v (%v)", summary.Mails, summary.Problems)
+	}
+	if summary.Requests["POST /dataset/release/{id}"] != 1 {
+		t.Errorf("expected the dataset to be released, got %v", summary.Requests)
+	}
+	if _, err := os.Stat(filepath.Join(output, "simulation.json")); err != nil {
+		t.Error(err)
+	}
+
+	store, err := history.Open(helpers.GetHistoryPath(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := store.List(history.Filter{})
+	store.Close() //nolint:errcheck
+	if err != nil || len(runs) != 1 || runs[0].Command != "simulate" {
+		t.Errorf("expected the run to be recorded as simulated, got %+v, %v", runs, err)
+	}
+
+	if _, err := Run(cfg, files, Options{Job: steps, OutputDir: output}); err == nil {
+		t.Error("expected error when the output directory is not empty")
+	}
+}
+
+func TestParseFailure(t *testing.T) {
+	for _, invalid := range []string{"POST dataset/create", "POST dataset/create abc", "POST dataset/create 999", "POST dataset/create 503 0"} {
+		if _, err := ParseFailure(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestInboxPath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"DATASET_ABC/IMAGES/a.c4gh":           "DATASET_ABC/IMAGES/a.c4gh",
+		"/DATASET_ABC/IMAGES/a.c4gh":          "DATASET_ABC/IMAGES/a.c4gh",
+		"/testuser/DATASET_ABC/IMAGES/a.c4gh": "DATASET_ABC/IMAGES/a.c4gh",
+		"testuser/OTHER/DATASET_ABC/a.c4gh":   "DATASET_ABC/a.c4gh",
+		"DATASET_ABCD/a.c4gh":                 "DATASET_ABCD/a.c4gh",
+	} {
+		if got := inboxPath(path, "DATASET_ABC"); got != expected {
+			t.Errorf("expected %s for %s, got %s", expected, path, got)
+		}
+	}
+}
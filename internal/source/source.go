@@ -0,0 +1,285 @@
+package source
+
+import (
+	"fmt"
+	"iter"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/NBISweden/submitter/cmd"
+	"github.com/NBISweden/submitter/internal/client"
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/database"
+	"github.com/NBISweden/submitter/internal/models"
+	"github.com/spf13/cobra"
+)
+
+// Kinds of file sources selected with --source
+const (
+	KindAPI = "api"
+	KindDB  = "db"
+)
+
+// FileSource lists the files a user has uploaded to the dataset folder that are not part of a
+// dataset yet. Every implementation applies the same filtering: disabled files and files outside
+// the dataset folder are left out, and accession ids are included when they have been assigned.
+// If statuses are given only files with one of them are listed.
+type FileSource interface {
+	Name() string
+	// Stream lists the files one at a time as they are read from the api or the database,
+	// breaking out of the loop stops the listing
+	Stream(statuses ...string) iter.Seq2[models.FileInfo, error]
+}
+
+// Files collects the files listed by src, for the checks that need all of them at once
+func Files(src FileSource, statuses ...string) ([]models.FileInfo, error) {
+	files := []models.FileInfo{}
+	for f, err := range src.Stream(statuses...) {
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	return files, nil
+}
+
+// API reads the files from the users/<user>/files endpoint of the SDA API
+type API struct {
+	api           client.FileLister
+	datasetFolder string
+}
+
+func (s *API) Name() string {
+	return KindAPI
+}
+
+func (s *API) Stream(statuses ...string) iter.Seq2[models.FileInfo, error] {
+	return filtered(s.api.UsersFiles(), s.datasetFolder, statuses)
+}
+
+// DB reads the files directly from the sda schema
+type DB struct {
+	db            database.Repository
+	userID        string
+	datasetFolder string
+}
+
+func (s *DB) Name() string {
+	return KindDB
+}
+
+func (s *DB) Stream(statuses ...string) iter.Seq2[models.FileInfo, error] {
+	return filtered(s.db.UserFiles(s.userID, s.datasetFolder, statuses, true), s.datasetFolder, statuses)
+}
+
+// filtered passes on the files of files that keep accepts, and the first error
+func filtered(files iter.Seq2[models.FileInfo, error], datasetFolder string, statuses []string) iter.Seq2[models.FileInfo, error] {
+	return func(yield func(models.FileInfo, error) bool) {
+		for f, err := range files {
+			if err != nil {
+				yield(models.FileInfo{}, err)
+				return
+			}
+			if keep(f, datasetFolder, statuses) && !yield(f, nil) {
+				return
+			}
+		}
+	}
+}
+
+// New returns the file source of kind, using the already created api client or database
+func New(kind string, cfg *config.Config, api client.FileLister, db database.Repository) (FileSource, error) {
+	switch kind {
+	case KindAPI:
+		if api == nil {
+			return nil, fmt.Errorf("the api file source requires an api client")
+		}
+		return &API{api: api, datasetFolder: cfg.DatasetFolder}, nil
+	case KindDB:
+		if db == nil {
+			return nil, fmt.Errorf("the db file source requires a database connection")
+		}
+		return &DB{db: db, userID: cfg.UserID, datasetFolder: cfg.DatasetFolder}, nil
+	default:
+		return nil, fmt.Errorf("unknown file source %q, use %s or %s", kind, KindAPI, KindDB)
+	}
+}
+
+// Open creates the api client or database connection needed for kind. The returned function
+// closes the api client or the database connection.
+func Open(kind string, cfg *config.Config) (FileSource, func(), error) {
+	switch kind {
+	case KindAPI:
+		api, err := client.New(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		s, err := New(kind, cfg, api, nil)
+		return s, func() { api.Close() }, err //nolint:errcheck
+	case KindDB:
+		db, err := database.New(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		s, err := New(kind, cfg, nil, db)
+		return s, db.Close, err
+	default:
+		s, err := New(kind, cfg, nil, nil)
+		return s, func() {}, err
+	}
+}
+
+// Filter keeps the files that are inside datasetFolder, not disabled and, if statuses are
+// given, have one of them
+func Filter(files []models.FileInfo, datasetFolder string, statuses ...string) []models.FileInfo {
+	filtered := []models.FileInfo{}
+	for _, f := range files {
+		if keep(f, datasetFolder, statuses) {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
+
+func keep(f models.FileInfo, datasetFolder string, statuses []string) bool {
+	if f.Status == "disabled" || !InFolder(f.InboxPath, datasetFolder) {
+		return false
+	}
+	return len(statuses) == 0 || slices.Contains(statuses, f.Status)
+}
+
+// InFolder reports whether inboxPath is inside datasetFolder, at any depth of the path
+func InFolder(inboxPath string, datasetFolder string) bool {
+	return strings.Contains("/"+strings.TrimPrefix(inboxPath, "/"), "/"+datasetFolder+"/")
+}
+
+// Difference is a file that is reported differently by two file sources
+type Difference struct {
+	Path    string
+	Message string
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: %s", d.Path, d.Message)
+}
+
+// Compare returns the differences between the files listed by source a and source b
+func Compare(a FileSource, aFiles []models.FileInfo, b FileSource, bFiles []models.FileInfo) []Difference {
+	byPath := func(files []models.FileInfo) map[string]models.FileInfo {
+		m := map[string]models.FileInfo{}
+		for _, f := range files {
+			m[strings.TrimPrefix(f.InboxPath, "/")] = f
+		}
+		return m
+	}
+	aByPath, bByPath := byPath(aFiles), byPath(bFiles)
+
+	var paths []string
+	for path := range aByPath {
+		paths = append(paths, path)
+	}
+	for path := range bByPath {
+		if _, ok := aByPath[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	var differences []Difference
+	for _, path := range paths {
+		af, inA := aByPath[path]
+		bf, inB := bByPath[path]
+		switch {
+		case !inB:
+			differences = append(differences, Difference{path, fmt.Sprintf("only listed by %s", a.Name())})
+		case !inA:
+			differences = append(differences, Difference{path, fmt.Sprintf("only listed by %s", b.Name())})
+		case af.Status != bf.Status:
+			differences = append(differences, Difference{path, fmt.Sprintf("status is %q in %s and %q in %s", af.Status, a.Name(), bf.Status, b.Name())})
+		case af.AccessionID != bf.AccessionID:
+			differences = append(differences, Difference{path, fmt.Sprintf("accession id is %q in %s and %q in %s", af.AccessionID, a.Name(), bf.AccessionID, b.Name())})
+		}
+	}
+
+	return differences
+}
+
+var configPath string
+var compare bool
+
+var filesCmd = &cobra.Command{
+	Use:   "files [flags]",
+	Short: "List the files of the dataset folder",
+	Long:  "List the files in the dataset folder that are not part of a dataset yet, from the source selected with --source, or compare what the api and the database report with --compare",
+	Args: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := cmd.LoadConfig(configPath)
+		if err != nil {
+			return err
+		}
+
+		if compare {
+			return runCompare(cfg)
+		}
+
+		src, closeSource, err := Open(cmd.SourceKind(KindAPI), cfg)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+
+		var listed int
+		for f, err := range src.Stream() {
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\t%s\t%s\n", f.Status, f.AccessionID, f.InboxPath)
+			listed++
+		}
+		slog.Info("listed files", "source", src.Name(), "files", listed)
+
+		return nil
+	},
+}
+
+func init() {
+	cmd.AddCommand(filesCmd)
+	filesCmd.Flags().StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
+	filesCmd.Flags().BoolVar(&compare, "compare", false, "Compare the files listed by the api and the database and report every difference")
+}
+
+func runCompare(cfg *config.Config) error {
+	apiSource, _, err := Open(KindAPI, cfg)
+	if err != nil {
+		return err
+	}
+	dbSource, closeDB, err := Open(KindDB, cfg)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	apiFiles, err := Files(apiSource)
+	if err != nil {
+		return fmt.Errorf("could not list files from the api: %w", err)
+	}
+	dbFiles, err := Files(dbSource)
+	if err != nil {
+		return fmt.Errorf("could not list files from the database: %w", err)
+	}
+
+	differences := Compare(apiSource, apiFiles, dbSource, dbFiles)
+	for _, d := range differences {
+		fmt.Println(d)
+	}
+	slog.Info("compared file sources", "api_files", len(apiFiles), "db_files", len(dbFiles), "differences", len(differences))
+
+	if len(differences) > 0 {
+		return fmt.Errorf("the api and the database disagree on %d files", len(differences))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package source
+
+import (
+	"testing"
+
+	"github.com/NBISweden/submitter/internal/config"
+	"github.com/NBISweden/submitter/internal/database"
+	"github.com/NBISweden/submitter/internal/models"
+)
+
+func TestFilter(t *testing.T) {
+	files := []models.FileInfo{
+		{InboxPath: "DATASET_ABC/IMAGES/a.c4gh", Status: "uploaded"},
+		{InboxPath: "/user/DATASET_ABC/IMAGES/b.c4gh", Status: "verified"},
+		{InboxPath: "DATASET_ABC/IMAGES/c.c4gh", Status: "disabled"},
+		{InboxPath: "DATASET_ABC2/IMAGES/d.c4gh", Status: "uploaded"},
+		{InboxPath: "OTHER/DATASET_ABC.c4gh", Status: "uploaded"},
+	}
+
+	filtered := Filter(files, "DATASET_ABC")
+	if len(filtered) != 2 {
+		t.Errorf("expected 2 files, got %v", filtered)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	apiFiles := []models.FileInfo{
+		{InboxPath: "DATASET_ABC/a.c4gh", Status: "verified", AccessionID: "aa-File-aaaaaa-aaaaaa"},
+		{InboxPath: "DATASET_ABC/b.c4gh", Status: "uploaded"},
+		{InboxPath: "DATASET_ABC/c.c4gh", Status: "uploaded"},
+	}
+	dbFiles := []models.FileInfo{
+		{InboxPath: "/DATASET_ABC/a.c4gh", Status: "verified", AccessionID: "aa-File-aaaaaa-aaaaaa"},
+		{InboxPath: "DATASET_ABC/b.c4gh", Status: "verified"},
+		{InboxPath: "DATASET_ABC/d.c4gh", Status: "uploaded"},
+	}
+
+	differences := Compare(&API{}, apiFiles, &DB{}, dbFiles)
+	if len(differences) != 3 {
+		t.Fatalf("expected 3 differences, got %v", differences)
+	}
+
+	expected := []string{
+		`DATASET_ABC/b.c4gh: status is "uploaded" in api and "verified" in db`,
+		"DATASET_ABC/c.c4gh: only listed by api",
+		"DATASET_ABC/d.c4gh: only listed by db",
+	}
+	for i, d := range differences {
+		if d.String() != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], d)
+		}
+	}
+}
+
+func TestDBStream(t *testing.T) {
+	db := database.NewMemory()
+	db.AddFile("user", models.FileInfo{InboxPath: "DATASET_ABC/IMAGES/a.c4gh", Status: "verified", AccessionID: "aa-File-aaaaaa-aaaaaa"})
+	db.AddFile("user", models.FileInfo{InboxPath: "DATASET_ABC/IMAGES/b.c4gh", Status: "uploaded"})
+	db.AddFile("user", models.FileInfo{InboxPath: "DATASET_ABC2/IMAGES/c.c4gh", Status: "verified"})
+	src, err := New(KindDB, &config.Config{UserID: "user", DatasetFolder: "DATASET_ABC"}, nil, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Files(src, "verified")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].AccessionID != "aa-File-aaaaaa-aaaaaa" {
+		t.Errorf("expected the verified file in the dataset folder with its accession id, got %v", files)
+	}
+
+	var read int
+	for range src.Stream() {
+		read++
+		break
+	}
+	if read != 1 {
+		t.Errorf("expected the listing to stop after the first file, read %d", read)
+	}
+}
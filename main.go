@@ -6,16 +6,24 @@ import (
 
 	"github.com/NBISweden/submitter/cmd"
 	_ "github.com/NBISweden/submitter/internal/accession"
+	_ "github.com/NBISweden/submitter/internal/artifacts"
 	_ "github.com/NBISweden/submitter/internal/dataset"
+	_ "github.com/NBISweden/submitter/internal/history"
 	_ "github.com/NBISweden/submitter/internal/ingest"
 	_ "github.com/NBISweden/submitter/internal/job"
+	_ "github.com/NBISweden/submitter/internal/lint"
 	_ "github.com/NBISweden/submitter/internal/mail"
+	_ "github.com/NBISweden/submitter/internal/metadata"
+	_ "github.com/NBISweden/submitter/internal/rems"
+	_ "github.com/NBISweden/submitter/internal/simulate"
+	_ "github.com/NBISweden/submitter/internal/source"
 )
 
 var version = "v1.1.0"
 
 func main() {
 	slog.Info("running", "version", version)
+	cmd.SetVersion(version)
 	err := cmd.Execute()
 	if err != nil {
 		os.Exit(1)